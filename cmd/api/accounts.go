@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// Account types. Balances are signed the same way for every type: money
+// owed on a credit card or loan is a negative balance.
+const (
+	accountChecking   = "checking"
+	accountSavings    = "savings"
+	accountCash       = "cash"
+	accountCreditCard = "credit_card"
+	accountLoan       = "loan"
+	accountInvestment = "investment"
+	accountAsset      = "asset"
+)
+
+var accountTypes = []string{
+	accountChecking, accountSavings, accountCash, accountCreditCard, accountLoan, accountInvestment, accountAsset,
+}
+
+// isLiability reports whether accounts of type t hold what the user owes.
+func isLiability(t string) bool {
+	return t == accountCreditCard || t == accountLoan
+}
+
+// normalize defaults the type to checking, rejects unknown types and
+// invalid type-specific fields, and drops the fields that do not apply to
+// the type: a credit limit only to credit cards, a statement day to credit
+// cards and loans, an interest rate to savings, credit cards and loans, and
+// an institution to everything but cash.
+func (a *Account) normalize() error {
+	a.Type = strings.ToLower(strings.TrimSpace(a.Type))
+	if a.Type == "" {
+		a.Type = accountChecking
+	}
+	known := false
+	for _, t := range accountTypes {
+		known = known || a.Type == t
+	}
+	if !known {
+		return errors.New("type must be one of " + strings.Join(accountTypes, ", "))
+	}
+	if a.Type != accountCreditCard {
+		a.CreditLimit = nil
+	}
+	if a.Type != accountCreditCard && a.Type != accountLoan {
+		a.StatementDay = nil
+	}
+	if a.Type != accountSavings && a.Type != accountCreditCard && a.Type != accountLoan {
+		a.InterestRate = nil
+	}
+	a.Institution = strings.TrimSpace(a.Institution)
+	if a.Type == accountCash {
+		a.Institution = ""
+	}
+	if a.CreditLimit != nil && *a.CreditLimit < 0 {
+		return errors.New("credit_limit cannot be negative")
+	}
+	if a.StatementDay != nil && (*a.StatementDay < 1 || *a.StatementDay > 31) {
+		return errors.New("statement_day must be between 1 and 31")
+	}
+	if a.InterestRate != nil && (*a.InterestRate < 0 || *a.InterestRate > 100) {
+		return errors.New("interest_rate must be a percentage between 0 and 100")
+	}
+	return nil
+}
+
+// balanceSheet sums the account balances into assets and liabilities, both
+// in the base currency. Liabilities are what is owed, so a credit card
+// balance of -850 adds 850 to them; an overpaid card lowers them.
+func balanceSheet(cv *currencyConverter, accounts []Account) (assets, liabilities Money, err error) {
+	var assetTotals, liabilityTotals []currencyTotal
+	for _, a := range accounts {
+		if isLiability(a.Type) {
+			liabilityTotals = append(liabilityTotals, currencyTotal{Currency: a.Currency, Total: -a.Amount})
+		} else {
+			assetTotals = append(assetTotals, currencyTotal{Currency: a.Currency, Total: a.Amount})
+		}
+	}
+	if assets, _, err = cv.Sum(assetTotals); err != nil {
+		return 0, 0, err
+	}
+	if liabilities, _, err = cv.Sum(liabilityTotals); err != nil {
+		return 0, 0, err
+	}
+	return assets, liabilities, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestAccountNormalize(t *testing.T) {
+	limit, day, rate := Money(500000), 25, 12.5
+	tests := []struct {
+		name        string
+		account     Account
+		wantType    string
+		limit       bool
+		day         bool
+		rate        bool
+		institution string
+		wantErr     bool
+	}{
+		{"default type", Account{}, accountChecking, false, false, false, "", false},
+		{"type is trimmed and lower cased", Account{Type: " Credit_Card "}, accountCreditCard, false, false, false, "", false},
+		{"unknown type", Account{Type: "crypto"}, "", false, false, false, "", true},
+		{"credit card keeps everything", Account{Type: accountCreditCard, CreditLimit: &limit, StatementDay: &day, InterestRate: &rate, Institution: " BCA "}, accountCreditCard, true, true, true, "BCA", false},
+		{"loan drops the credit limit", Account{Type: accountLoan, CreditLimit: &limit, StatementDay: &day, InterestRate: &rate}, accountLoan, false, true, true, "", false},
+		{"savings keeps the interest rate", Account{Type: accountSavings, CreditLimit: &limit, StatementDay: &day, InterestRate: &rate, Institution: "BCA"}, accountSavings, false, false, true, "BCA", false},
+		{"checking drops the card fields", Account{Type: accountChecking, CreditLimit: &limit, StatementDay: &day, InterestRate: &rate, Institution: "BCA"}, accountChecking, false, false, false, "BCA", false},
+		{"cash drops the institution", Account{Type: accountCash, CreditLimit: &limit, StatementDay: &day, InterestRate: &rate, Institution: "BCA"}, accountCash, false, false, false, "", false},
+		{"investment", Account{Type: accountInvestment, InterestRate: &rate, Institution: "IDX"}, accountInvestment, false, false, false, "IDX", false},
+	}
+	for _, tt := range tests {
+		a := tt.account
+		err := a.normalize()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if a.Type != tt.wantType || (a.CreditLimit != nil) != tt.limit || (a.StatementDay != nil) != tt.day ||
+			(a.InterestRate != nil) != tt.rate || a.Institution != tt.institution {
+			t.Errorf("%s: got type %q, credit limit %v, statement day %v, interest rate %v, institution %q", tt.name,
+				a.Type, a.CreditLimit != nil, a.StatementDay != nil, a.InterestRate != nil, a.Institution)
+		}
+	}
+
+	// invalid values are rejected only when they apply to the type
+	negative, lateDay, highRate := Money(-1), 32, 101.0
+	for _, a := range []Account{
+		{Type: accountCreditCard, CreditLimit: &negative},
+		{Type: accountLoan, StatementDay: &lateDay},
+		{Type: accountSavings, InterestRate: &highRate},
+	} {
+		if err := a.normalize(); err == nil {
+			t.Errorf("normalize(%s) accepted an invalid field", a.Type)
+		}
+	}
+	a := Account{Type: accountChecking, CreditLimit: &negative, StatementDay: &lateDay, InterestRate: &highRate}
+	if err := a.normalize(); err != nil {
+		t.Errorf("normalize(checking) = %v for fields it drops", err)
+	}
+}
+
+func TestBalanceSheet(t *testing.T) {
+	cv := &currencyConverter{
+		base:  "IDR",
+		rates: map[string]*big.Rat{"USD": big.NewRat(15000, 1)},
+		used:  map[string]AppliedRate{},
+	}
+	accounts := []Account{
+		{Type: accountChecking, Amount: 100000, Currency: "IDR"},
+		{Type: accountSavings, Amount: 1000, Currency: "USD"},
+		{Type: accountCreditCard, Amount: -85000, Currency: "IDR"},
+		// an overpaid card is owed to the user and lowers the liabilities
+		{Type: accountCreditCard, Amount: 5000, Currency: "IDR"},
+		{Type: accountLoan, Amount: -1000, Currency: "USD"},
+	}
+	assets, liabilities, err := balanceSheet(cv, accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assets != 15100000 || liabilities != 15080000 {
+		t.Errorf("balanceSheet = %s assets, %s liabilities; want 151000.00 and 150800.00", assets, liabilities)
+	}
+}
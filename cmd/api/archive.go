@@ -0,0 +1,478 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// An archive is a zip file holding manifest.json and one JSON Lines file per
+// table, each line being a row as the API returns it. Version is raised
+// whenever a change would make older servers misread an archive.
+const (
+	archiveFormat  = "finance-manager-archive"
+	archiveVersion = 1
+)
+
+type archiveManifest struct {
+	Format       string    `json:"format"`
+	Version      int       `json:"version"`
+	ExportedAt   time.Time `json:"exported_at"`
+	BaseCurrency string    `json:"base_currency"`
+	// EnvelopeStart is the first month of envelope budgeting, if used.
+	EnvelopeStart *time.Time     `json:"envelope_start,omitempty"`
+	Counts        map[string]int `json:"counts"`
+}
+
+var errInvalidArchive = errors.New("invalid archive")
+
+// exportTable writes every row selected by query to name, one JSON document
+// per line.
+func exportTable[T any](query *gorm.DB, zw *zip.Writer, name string) (int, error) {
+	w, err := zw.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	enc := json.NewEncoder(w)
+	var batch []T
+	count := 0
+	err = query.FindInBatches(&batch, 500, func(*gorm.DB, int) error {
+		for _, row := range batch {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+		count += len(batch)
+		return nil
+	}).Error
+	return count, err
+}
+
+// importTable calls fn for every row of name. A missing file holds no rows.
+func importTable[T any](zr *zip.Reader, name string, fn func(*T) error) (int, error) {
+	f, err := zr.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", errInvalidArchive, name, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	count := 0
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		count++
+		var row T
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return count, fmt.Errorf("%w: %s line %d: %v", errInvalidArchive, name, count, err)
+		}
+		if err := fn(&row); err != nil {
+			return count, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("%w: %s: %v", errInvalidArchive, name, err)
+	}
+	return count, nil
+}
+
+// ExportArchive streams all of the user's data as a zip archive that
+// ImportArchive can restore on this or another instance.
+func (h *Handler) ExportArchive(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	manifest := archiveManifest{
+		Format:       archiveFormat,
+		Version:      archiveVersion,
+		ExportedAt:   time.Now().UTC(),
+		BaseCurrency: h.baseCurrency(userID),
+		Counts:       map[string]int{},
+	}
+	if start, ok := h.envelopeStart(userID); ok {
+		manifest.EnvelopeStart = &start
+	}
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="finance-manager-%s.zip"`, manifest.ExportedAt.Format("20060102-150405")))
+	c.Status(http.StatusOK)
+	zw := zip.NewWriter(c.Writer)
+	// one snapshot, so balances and transactions agree
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		owned := tx.Where("user_id = ?", userID).Session(&gorm.Session{})
+		tables := []struct {
+			name   string
+			export func() (int, error)
+		}{
+			{"categories", func() (int, error) { return exportTable[Category](owned, zw, "categories.jsonl") }},
+			{"accounts", func() (int, error) { return exportTable[Account](owned, zw, "accounts.jsonl") }},
+			{"transactions", func() (int, error) {
+				return exportTable[Transaction](owned.Preload("Splits"), zw, "transactions.jsonl")
+			}},
+			{"transfers", func() (int, error) { return exportTable[Transfer](owned, zw, "transfers.jsonl") }},
+			{"budgets", func() (int, error) { return exportTable[Budget](owned, zw, "budgets.jsonl") }},
+			{"envelope_allocations", func() (int, error) {
+				return exportTable[EnvelopeAllocation](owned, zw, "envelope_allocations.jsonl")
+			}},
+			{"scheduled_transactions", func() (int, error) {
+				return exportTable[ScheduledTransaction](owned, zw, "scheduled_transactions.jsonl")
+			}},
+			// only exceptions still to apply, the posted occurrences are not archived
+			{"scheduled_exceptions", func() (int, error) {
+				return exportTable[ScheduledException](owned.Where(pendingExceptionSQL), zw, "scheduled_exceptions.jsonl")
+			}},
+			{"exchange_rates", func() (int, error) { return exportTable[ExchangeRate](owned, zw, "exchange_rates.jsonl") }},
+			{"import_profiles", func() (int, error) { return exportTable[ImportProfile](owned, zw, "import_profiles.jsonl") }},
+			{"rules", func() (int, error) { return exportTable[Rule](owned, zw, "rules.jsonl") }},
+			{"reconciliations", func() (int, error) {
+				return exportTable[Reconciliation](owned, zw, "reconciliations.jsonl")
+			}},
+		}
+		for _, table := range tables {
+			count, err := table.export()
+			if err != nil {
+				return err
+			}
+			manifest.Counts[table.name] = count
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err == nil {
+		var w io.Writer
+		if w, err = zw.Create("manifest.json"); err == nil {
+			err = json.NewEncoder(w).Encode(manifest)
+		}
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// the status is already sent; a truncated archive fails to open
+		log.Printf("export for user %d failed: %v", userID, err)
+		c.Abort()
+	}
+}
+
+// ImportArchive restores an archive made by ExportArchive into the current
+// user. Rows get new IDs and every reference between them is remapped. With
+// replace=true the user's existing data is deleted first; otherwise the
+// archive is added to it. Either everything is restored or nothing is.
+func (h *Handler) ImportArchive(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing archive file"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	zr, err := zip.NewReader(f, file.Size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archive is not a zip file"})
+		return
+	}
+	mf, err := zr.Open("manifest.json")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "archive has no manifest"})
+		return
+	}
+	var manifest archiveManifest
+	err = json.NewDecoder(mf).Decode(&manifest)
+	mf.Close()
+	if err != nil || manifest.Format != archiveFormat {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not a finance manager archive"})
+		return
+	}
+	if manifest.Version < 1 || manifest.Version > archiveVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported archive version %d", manifest.Version)})
+		return
+	}
+	replace := c.PostForm("replace") == "true"
+	counts := map[string]int{}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if replace {
+			if err := deleteUserData(tx, userID); err != nil {
+				return err
+			}
+			if base, err := normalizeCurrency(manifest.BaseCurrency); err == nil {
+				if err := tx.Model(&User{}).Where("id = ?", userID).Update("base_currency", base).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&User{}).Where("id = ?", userID).Update("envelope_start", manifest.EnvelopeStart).Error; err != nil {
+				return err
+			}
+		}
+		return restoreArchive(tx, zr, userID, counts)
+	})
+	if errors.Is(err, errInvalidArchive) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"version": manifest.Version, "replaced": replace, "counts": counts})
+}
+
+// deleteUserData removes everything the user owns except the user itself.
+func deleteUserData(tx *gorm.DB, userID uint) error {
+	for _, model := range []interface{}{
+		&Notification{}, &Transaction{}, &Transfer{}, &Budget{}, &EnvelopeAllocation{},
+		&ScheduledException{}, &ScheduledTransaction{}, &ExchangeRate{}, &ImportProfile{},
+		&Rule{}, &Reconciliation{}, &Account{}, &Category{},
+	} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idMap translates the IDs of one archived table into the restored ones.
+type idMap struct {
+	table string
+	ids   map[uint]uint
+}
+
+func newIDMap(table string) *idMap {
+	return &idMap{table: table, ids: map[uint]uint{}}
+}
+
+// ref remaps an optional reference.
+func (m *idMap) ref(id *uint) (*uint, error) {
+	if id == nil {
+		return nil, nil
+	}
+	newID, ok := m.ids[*id]
+	if !ok {
+		return nil, fmt.Errorf("%w: reference to unknown %s %d", errInvalidArchive, m.table, *id)
+	}
+	return &newID, nil
+}
+
+// restoreArchive inserts the archived rows for userID, parents before the
+// rows that refer to them, and records how many rows each table restored.
+func restoreArchive(tx *gorm.DB, zr *zip.Reader, userID uint, counts map[string]int) error {
+	categories := newIDMap("category")
+	accounts := newIDMap("account")
+	scheduled := newIDMap("scheduled transaction")
+	var err error
+
+	counts["categories"], err = importTable(zr, "categories.jsonl", func(cat *Category) error {
+		oldID := cat.ID
+		cat.ID, cat.UserID = 0, userID
+		if err := tx.Create(cat).Error; err != nil {
+			return err
+		}
+		categories.ids[oldID] = cat.ID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["accounts"], err = importTable(zr, "accounts.jsonl", func(acc *Account) error {
+		var err error
+		oldID := acc.ID
+		acc.ID, acc.UserID = 0, userID
+		if acc.Currency, err = normalizeCurrency(acc.Currency); err != nil {
+			return fmt.Errorf("%w: account %d: %v", errInvalidArchive, oldID, err)
+		}
+		if err := acc.normalize(); err != nil {
+			return fmt.Errorf("%w: account %d: %v", errInvalidArchive, oldID, err)
+		}
+		if err := tx.Create(acc).Error; err != nil {
+			return err
+		}
+		accounts.ids[oldID] = acc.ID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// account balances already include the transactions and transfers, so
+	// these are restored without touching them
+	counts["transactions"], err = importTable(zr, "transactions.jsonl", func(t *Transaction) error {
+		var err error
+		t.ID, t.UserID = 0, userID
+		t.Category, t.Account = nil, nil
+		if t.CategoryID, err = categories.ref(t.CategoryID); err != nil {
+			return err
+		}
+		if t.AccountID, err = accounts.ref(t.AccountID); err != nil {
+			return err
+		}
+		for i := range t.Splits {
+			s := &t.Splits[i]
+			s.ID, s.TransactionID, s.Category = 0, 0, nil
+			if s.CategoryID, err = categories.ref(s.CategoryID); err != nil {
+				return err
+			}
+		}
+		normalizeTransactionDates(t)
+		return tx.Create(t).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["transfers"], err = importTable(zr, "transfers.jsonl", func(t *Transfer) error {
+		var err error
+		t.ID, t.UserID = 0, userID
+		t.FromAccount, t.ToAccount = nil, nil
+		if t.FromAccountID, err = accounts.ref(t.FromAccountID); err != nil {
+			return err
+		}
+		if t.ToAccountID, err = accounts.ref(t.ToAccountID); err != nil {
+			return err
+		}
+		return tx.Create(t).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["budgets"], err = importTable(zr, "budgets.jsonl", func(b *Budget) error {
+		b.ID, b.UserID = 0, userID
+		// archives from before budgets had several categories carry one
+		if b.CategoryID != nil {
+			b.CategoryIDs, b.CategoryID = append(b.CategoryIDs, *b.CategoryID), nil
+		}
+		for i := range b.CategoryIDs {
+			id, err := categories.ref(&b.CategoryIDs[i])
+			if err != nil {
+				return err
+			}
+			b.CategoryIDs[i] = *id
+		}
+		for i := range b.AccountIDs {
+			id, err := accounts.ref(&b.AccountIDs[i])
+			if err != nil {
+				return err
+			}
+			b.AccountIDs[i] = *id
+		}
+		return tx.Create(b).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["envelope_allocations"], err = importTable(zr, "envelope_allocations.jsonl", func(a *EnvelopeAllocation) error {
+		a.ID, a.UserID = 0, userID
+		categoryID, err := categories.ref(&a.CategoryID)
+		if err != nil {
+			return err
+		}
+		a.CategoryID = *categoryID
+		// merged into existing data, the archived amounts add up
+		return addAllocation(tx, userID, a.CategoryID, monthStart(a.Month), a.Amount)
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["scheduled_transactions"], err = importTable(zr, "scheduled_transactions.jsonl", func(st *ScheduledTransaction) error {
+		var err error
+		oldID := st.ID
+		st.ID, st.UserID, st.Category, st.Account = 0, userID, nil, nil
+		if st.CategoryID, err = categories.ref(st.CategoryID); err != nil {
+			return err
+		}
+		if st.AccountID, err = accounts.ref(st.AccountID); err != nil {
+			return err
+		}
+		if err := tx.Create(st).Error; err != nil {
+			return err
+		}
+		scheduled.ids[oldID] = st.ID
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["scheduled_exceptions"], err = importTable(zr, "scheduled_exceptions.jsonl", func(ex *ScheduledException) error {
+		ex.ID, ex.UserID = 0, userID
+		scheduledID, err := scheduled.ref(&ex.ScheduledTransactionID)
+		if err != nil {
+			return err
+		}
+		ex.ScheduledTransactionID = *scheduledID
+		return tx.Create(ex).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["exchange_rates"], err = importTable(zr, "exchange_rates.jsonl", func(r *ExchangeRate) error {
+		r.ID, r.UserID = 0, userID
+		if _, err := parseRate(r.Rate); err != nil {
+			return fmt.Errorf("%w: exchange rate: %v", errInvalidArchive, err)
+		}
+		// rates the user already has for the same day win
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(r).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["import_profiles"], err = importTable(zr, "import_profiles.jsonl", func(p *ImportProfile) error {
+		p.ID, p.UserID = 0, userID
+		if err := p.normalize(); err != nil {
+			return fmt.Errorf("%w: import profile %q: %v", errInvalidArchive, p.Name, err)
+		}
+		return tx.Create(p).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["rules"], err = importTable(zr, "rules.jsonl", func(r *Rule) error {
+		r.ID, r.UserID = 0, userID
+		if err := r.normalize(); err != nil {
+			return fmt.Errorf("%w: rule %q: %v", errInvalidArchive, r.Name, err)
+		}
+		if r.AccountID, err = accounts.ref(r.AccountID); err != nil {
+			return err
+		}
+		if r.SetCategoryID, err = categories.ref(r.SetCategoryID); err != nil {
+			return err
+		}
+		return tx.Create(r).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	counts["reconciliations"], err = importTable(zr, "reconciliations.jsonl", func(r *Reconciliation) error {
+		r.ID, r.UserID = 0, userID
+		accountID, err := accounts.ref(&r.AccountID)
+		if err != nil {
+			return err
+		}
+		r.AccountID = *accountID
+		return tx.Create(r).Error
+	})
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func TestImportTable(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"categories.jsonl": "{\"id\":4,\"name\":\"Food\"}\n\n{\"id\":9,\"name\":\"Rent\"}\n",
+		"broken.jsonl":     "{\"id\":4}\n{\"id\":\n",
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	count, err := importTable(zr, "categories.jsonl", func(cat *Category) error {
+		names = append(names, cat.Name)
+		return nil
+	})
+	if err != nil || count != 2 || !reflect.DeepEqual(names, []string{"Food", "Rent"}) {
+		t.Errorf("importTable = %d %v, %v; want the two categories", count, names, err)
+	}
+	if count, err := importTable(zr, "rules.jsonl", func(*Rule) error { return nil }); count != 0 || err != nil {
+		t.Errorf("importTable of a missing file = %d, %v; want no rows", count, err)
+	}
+	if _, err := importTable(zr, "broken.jsonl", func(*Category) error { return nil }); !errors.Is(err, errInvalidArchive) {
+		t.Errorf("importTable of a broken file error = %v, want an invalid archive", err)
+	}
+}
+
+func TestIDMapRef(t *testing.T) {
+	m := newIDMap("category")
+	m.ids[4] = 40
+	old, unknown := uint(4), uint(5)
+	if id, err := m.ref(&old); err != nil || id == nil || *id != 40 {
+		t.Errorf("ref(4) = %v, %v; want 40", id, err)
+	}
+	if id, err := m.ref(nil); err != nil || id != nil {
+		t.Errorf("ref(nil) = %v, %v; want nil", id, err)
+	}
+	if _, err := m.ref(&unknown); !errors.Is(err, errInvalidArchive) {
+		t.Errorf("ref(5) error = %v, want an invalid archive", err)
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	from, to := testUser(t, db), testUser(t, db)
+	create := func(rows ...interface{}) {
+		t.Helper()
+		for _, row := range rows {
+			if err := db.Create(row).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	food, rent := Category{Name: "Food", UserID: from.ID}, Category{Name: "Rent", UserID: from.ID}
+	create(&food, &rent)
+	bank := testAccount(t, db, from.ID, accountChecking, 100000)
+	savings := testAccount(t, db, from.ID, accountSavings, 500)
+	create(
+		&Transaction{Name: "Market", Amount: -1000, Currency: "IDR", Date: mustDate(t, "2024-03-05"), UserID: from.ID, AccountID: &bank.ID,
+			Splits: []TransactionSplit{{Amount: -600, CategoryID: &food.ID}, {Amount: -400, CategoryID: &rent.ID}}},
+		&Transaction{Name: "Landlord", Amount: -5000, Currency: "IDR", Date: mustDate(t, "2024-03-01"), UserID: from.ID, AccountID: &bank.ID, CategoryID: &rent.ID},
+		&Transfer{FromAccountID: &bank.ID, ToAccountID: &savings.ID, Amount: 500, ToAmount: 500, Date: mustDate(t, "2024-03-02"), UserID: from.ID},
+		&Budget{Name: "Food", CategoryIDs: IDList{food.ID}, AccountIDs: IDList{bank.ID}, Amount: 5000, Criteria: budgetMonthly, UserID: from.ID},
+		&Rule{Name: "market", NameContains: "market", AccountID: &bank.ID, SetCategoryID: &food.ID, UserID: from.ID},
+		&Reconciliation{AccountID: bank.ID, StatementDate: mustDate(t, "2024-03-03"), StatementBalance: 100000, UserID: from.ID},
+	)
+	scheduled := ScheduledTransaction{Name: "Rent", Amount: -5000, Repetition: "monthly", StartsAt: mustDate(t, "2024-04-01"),
+		RepeatAt: mustDate(t, "2024-04-01"), UserID: from.ID, AccountID: &bank.ID, CategoryID: &rent.ID}
+	create(&scheduled)
+	create(&ScheduledException{ScheduledTransactionID: scheduled.ID, OccursOn: mustDate(t, "2024-05-01"), Skip: true, UserID: from.ID})
+	// replaced by the archive
+	create(&Category{Name: "Old", UserID: to.ID})
+
+	export := serve(h.ExportArchive, from.ID, "/export", nil, nil)
+	if export.Code != http.StatusOK {
+		t.Fatalf("export: status = %d", export.Code)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "archive.zip")
+	fw.Write(export.Body.Bytes())
+	mw.WriteField("replace", "true")
+	mw.Close()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/import/archive", &body)
+	c.Request.Header.Set("Content-Type", mw.FormDataContentType())
+	c.Set("user_id", to.ID)
+	h.ImportArchive(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("import: status = %d: %s", w.Code, w.Body)
+	}
+
+	// every reference points at the restored rows of the new user
+	categories, accounts := map[string]uint{}, map[string]uint{}
+	var cats []Category
+	db.Where("user_id = ?", to.ID).Find(&cats)
+	for _, cat := range cats {
+		categories[cat.Name] = cat.ID
+	}
+	var accs []Account
+	db.Where("user_id = ?", to.ID).Find(&accs)
+	for _, acc := range accs {
+		accounts[acc.BankName] = acc.ID
+		if acc.BankName == bank.BankName && acc.Amount != 100000 {
+			t.Errorf("restored balance = %s, want 1000.00", acc.Amount)
+		}
+	}
+	if len(categories) != 2 || categories["Old"] != 0 || categories["Food"] == food.ID || len(accounts) != 2 {
+		t.Fatalf("restored categories %v and accounts %v", categories, accounts)
+	}
+	ref := func(id uint) *uint { return &id }
+	newBank, newFood, newRent := accounts[bank.BankName], categories["Food"], categories["Rent"]
+
+	var transactions []Transaction
+	db.Where("user_id = ?", to.ID).Preload("Splits", func(db *gorm.DB) *gorm.DB { return db.Order("amount") }).
+		Order("date").Find(&transactions)
+	if len(transactions) != 2 || !reflect.DeepEqual(transactions[0].CategoryID, ref(newRent)) ||
+		!reflect.DeepEqual(transactions[1].AccountID, ref(newBank)) || len(transactions[1].Splits) != 2 ||
+		!reflect.DeepEqual(transactions[1].Splits[0].CategoryID, ref(newFood)) {
+		t.Errorf("restored transactions = %+v", transactions)
+	}
+	var transfer Transfer
+	db.Where("user_id = ?", to.ID).First(&transfer)
+	if !reflect.DeepEqual(transfer.FromAccountID, ref(newBank)) || !reflect.DeepEqual(transfer.ToAccountID, ref(accounts[savings.BankName])) {
+		t.Errorf("restored transfer = %+v", transfer)
+	}
+	var budget Budget
+	db.Where("user_id = ?", to.ID).First(&budget)
+	if !reflect.DeepEqual(budget.CategoryIDs, IDList{newFood}) || !reflect.DeepEqual(budget.AccountIDs, IDList{newBank}) {
+		t.Errorf("restored budget = %v %v", budget.CategoryIDs, budget.AccountIDs)
+	}
+	var st ScheduledTransaction
+	db.Where("user_id = ?", to.ID).First(&st)
+	var ex ScheduledException
+	db.Where("user_id = ?", to.ID).First(&ex)
+	if !reflect.DeepEqual(st.CategoryID, ref(newRent)) || !reflect.DeepEqual(st.AccountID, ref(newBank)) || ex.ScheduledTransactionID != st.ID {
+		t.Errorf("restored schedule = %+v with exception %+v", st, ex)
+	}
+	var rule Rule
+	db.Where("user_id = ?", to.ID).First(&rule)
+	if !reflect.DeepEqual(rule.SetCategoryID, ref(newFood)) || !reflect.DeepEqual(rule.AccountID, ref(newBank)) {
+		t.Errorf("restored rule = %+v", rule)
+	}
+	var reconciliation Reconciliation
+	db.Where("user_id = ?", to.ID).First(&reconciliation)
+	if reconciliation.AccountID != newBank {
+		t.Errorf("restored reconciliation = %+v", reconciliation)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/clause"
+)
+
+// defaultAlertThresholds warn when 80% of a budget is spent and alert when
+// all of it is.
+var defaultAlertThresholds = Percentages{80, 100}
+
+// Percentages is a list of whole percentages, stored as a comma separated
+// list.
+type Percentages []int
+
+func (p Percentages) Value() (driver.Value, error) {
+	parts := make([]string, len(p))
+	for i, v := range p {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (p *Percentages) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Percentages", src)
+	}
+	list := Percentages{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("invalid percentage %q", part)
+		}
+		list = append(list, v)
+	}
+	*p = list
+	return nil
+}
+
+// normalizeThresholds sorts and deduplicates the alert thresholds of b,
+// defaulting to defaultAlertThresholds. An empty list turns alerts off.
+func normalizeThresholds(b *Budget) error {
+	if b.AlertThresholds == nil {
+		b.AlertThresholds = append(Percentages{}, defaultAlertThresholds...)
+		return nil
+	}
+	seen := map[int]bool{}
+	thresholds := Percentages{}
+	for _, v := range b.AlertThresholds {
+		if v < 1 || v > 1000 {
+			return errors.New("alert_thresholds must be percentages between 1 and 1000")
+		}
+		if !seen[v] {
+			seen[v] = true
+			thresholds = append(thresholds, v)
+		}
+	}
+	sort.Ints(thresholds)
+	b.AlertThresholds = thresholds
+	return nil
+}
+
+const (
+	notificationBudgetThreshold = "budget_threshold"
+	notificationWarning         = "warning"
+	notificationAlert           = "alert"
+)
+
+// Notification is an entry of a user's in-app notification feed. Budget
+// threshold notifications are raised once per budget, period and threshold.
+type Notification struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	Kind        string     `gorm:"size:50;not null" json:"kind"`
+	Level       string     `gorm:"size:20;not null" json:"level"`
+	Title       string     `gorm:"size:255;not null" json:"title"`
+	Message     string     `gorm:"size:500" json:"message"`
+	BudgetID    *uint      `gorm:"index" json:"budget_id"`
+	PeriodStart *time.Time `gorm:"type:date" json:"period_start"`
+	Threshold   int        `gorm:"not null" json:"threshold"`
+	ReadAt      *time.Time `json:"read_at"`
+	UserID      uint       `gorm:"not null;index" json:"user_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// budgetTouch is a day on which the spending of a category from an account
+// changed.
+type budgetTouch struct {
+	CategoryID *uint
+	AccountID  *uint
+	Date       time.Time
+}
+
+// transactionTouches lists the categories t books to, those of its split
+// lines when it has any, from its account on its date.
+func transactionTouches(t *Transaction) []budgetTouch {
+	accountID, date := t.AccountID, dateOnly(t.Date)
+	if len(t.Splits) == 0 {
+		return []budgetTouch{{t.CategoryID, accountID, date}}
+	}
+	var touches []budgetTouch
+	for _, s := range t.Splits {
+		touches = append(touches, budgetTouch{s.CategoryID, accountID, date})
+	}
+	return touches
+}
+
+// checkBudgetAlerts evaluates the alert thresholds of the budgets affected
+// by the touched categories and accounts, in the period of each touched
+// day, and adds a notification for every threshold the spending has
+// reached. It runs after the change is committed; failures are logged,
+// never returned.
+func (h *Handler) checkBudgetAlerts(userID uint, touches []budgetTouch) {
+	if len(touches) == 0 {
+		return
+	}
+	var budgets []Budget
+	if err := h.DB.Where("user_id = ?", userID).Find(&budgets).Error; err != nil {
+		log.Printf("budget alerts for user %d: %v", userID, err)
+		return
+	}
+	cv := h.newConverter(userID, time.Now())
+	checked := map[string]bool{}
+	for i := range budgets {
+		b := &budgets[i]
+		if len(b.AlertThresholds) == 0 {
+			continue
+		}
+		for _, touch := range touches {
+			if !b.counts(touch.CategoryID, touch.AccountID) {
+				continue
+			}
+			start, end := budgetPeriod(b, touch.Date)
+			key := fmt.Sprintf("%d/%s", b.ID, start.Format("2006-01-02"))
+			if checked[key] || touch.Date.Before(start) || !touch.Date.Before(end) {
+				continue
+			}
+			checked[key] = true
+			if err := h.raiseBudgetAlerts(cv, userID, b, touch.Date); err != nil {
+				log.Printf("budget alerts for budget %d: %v", b.ID, err)
+			}
+		}
+	}
+}
+
+func (h *Handler) raiseBudgetAlerts(cv *currencyConverter, userID uint, b *Budget, day time.Time) error {
+	p, err := h.currentBudgetPeriod(cv, userID, b, day)
+	if err != nil {
+		return err
+	}
+	if p.Spent <= 0 {
+		return nil
+	}
+	name, err := h.budgetName(userID, b)
+	if err != nil {
+		return err
+	}
+	for _, threshold := range reachedThresholds(b.AlertThresholds, p.Spent, p.Available) {
+		level := notificationWarning
+		if threshold >= 100 {
+			level = notificationAlert
+		}
+		start := p.Start
+		n := Notification{
+			Kind:  notificationBudgetThreshold,
+			Level: level,
+			Title: fmt.Sprintf("%s budget at %d%%", name, threshold),
+			Message: fmt.Sprintf("%s of %s %s spent from %s to %s.", p.Spent, p.Available, cv.base,
+				p.Start.Format("2006-01-02"), p.End.AddDate(0, 0, -1).Format("2006-01-02")),
+			BudgetID:    &b.ID,
+			PeriodStart: &start,
+			Threshold:   threshold,
+			UserID:      userID,
+		}
+		err := h.DB.Clauses(clause.OnConflict{
+			Columns:     []clause.Column{{Name: "budget_id"}, {Name: "period_start"}, {Name: "threshold"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "budget_id IS NOT NULL"}}},
+			DoNothing:   true,
+		}).Create(&n).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reachedThresholds lists the thresholds spent has reached as a percentage
+// of available. Nothing available counts as over every threshold.
+func reachedThresholds(thresholds Percentages, spent, available Money) Percentages {
+	var reached Percentages
+	for _, threshold := range thresholds {
+		if available > 0 && spent*100 < available*Money(threshold) {
+			continue
+		}
+		reached = append(reached, threshold)
+	}
+	return reached
+}
+
+// budgetName is the name of b, or else the names of its categories and
+// accounts.
+func (h *Handler) budgetName(userID uint, b *Budget) (string, error) {
+	if b.Name != "" {
+		return b.Name, nil
+	}
+	var names []string
+	if len(b.CategoryIDs) > 0 {
+		err := h.DB.Model(&Category{}).Where("id IN ? AND user_id = ?", []uint(b.CategoryIDs), userID).
+			Order("name").Pluck("name", &names).Error
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(b.AccountIDs) > 0 {
+		var accounts []string
+		err := h.DB.Model(&Account{}).Where("id IN ? AND user_id = ?", []uint(b.AccountIDs), userID).
+			Order("bank_name").Pluck("bank_name", &accounts).Error
+		if err != nil {
+			return "", err
+		}
+		names = append(names, accounts...)
+	}
+	if len(names) == 0 {
+		return "Budget", nil
+	}
+	return strings.Join(names, ", "), nil
+}
+
+const (
+	defaultNotificationPageSize = 50
+	maxNotificationPageSize     = 200
+)
+
+// GetNotifications lists the user's notifications, newest first. unread=true
+// lists only unread ones; pass the returned next_cursor as cursor for the
+// next page.
+func (h *Handler) GetNotifications(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	limit := defaultNotificationPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxNotificationPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxNotificationPageSize)})
+			return
+		}
+		limit = n
+	}
+	query := h.DB.Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+	if v := c.Query("cursor"); v != "" {
+		before, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		query = query.Where("id < ?", before)
+	}
+	var notifications []Notification
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var nextCursor string
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		nextCursor = strconv.FormatUint(uint64(notifications[limit-1].ID), 10)
+	}
+	var unread int64
+	h.DB.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&unread)
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"next_cursor":   nextCursor,
+		"unread_count":  unread,
+	})
+}
+
+// UpdateNotification marks a notification read or unread.
+func (h *Handler) UpdateNotification(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		Read *bool `json:"read" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var n Notification
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&n).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	n.ReadAt = nil
+	if *req.Read {
+		now := time.Now()
+		n.ReadAt = &now
+	}
+	if err := h.DB.Model(&n).Update("read_at", n.ReadAt).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, n)
+}
+
+// ReadAllNotifications marks every unread notification read.
+func (h *Handler) ReadAllNotifications(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	result := h.DB.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"updated": result.RowsAffected})
+}
+
+func (h *Handler) DeleteNotification(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	if result := h.DB.Where("user_id = ?", userID).Delete(&Notification{}, c.Param("id")); result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds Percentages
+		want       Percentages
+		wantErr    bool
+	}{
+		{"default", nil, Percentages{80, 100}, false},
+		{"off", Percentages{}, Percentages{}, false},
+		{"sorted and deduplicated", Percentages{100, 50, 100, 75}, Percentages{50, 75, 100}, false},
+		{"over the budget", Percentages{120, 1000}, Percentages{120, 1000}, false},
+		{"zero", Percentages{0, 50}, nil, true},
+		{"too large", Percentages{1001}, nil, true},
+	}
+	for _, tt := range tests {
+		b := Budget{AlertThresholds: tt.thresholds}
+		err := normalizeThresholds(&b)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(b.AlertThresholds, tt.want) {
+			t.Errorf("%s: thresholds = %v, want %v", tt.name, b.AlertThresholds, tt.want)
+		}
+	}
+}
+
+func TestReachedThresholds(t *testing.T) {
+	thresholds := Percentages{50, 80, 100, 120}
+	tests := []struct {
+		name      string
+		spent     Money
+		available Money
+		want      Percentages
+	}{
+		{"below every threshold", 4999, 10000, nil},
+		{"exactly half", 5000, 10000, Percentages{50}},
+		{"just short of 80%", 7999, 10000, Percentages{50}},
+		{"all of it", 10000, 10000, Percentages{50, 80, 100}},
+		{"over the budget", 12000, 10000, Percentages{50, 80, 100, 120}},
+		{"nothing available", 1, 0, Percentages{50, 80, 100, 120}},
+		{"overspent rollover", 1, -500, Percentages{50, 80, 100, 120}},
+	}
+	for _, tt := range tests {
+		if got := reachedThresholds(thresholds, tt.spent, tt.available); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: reachedThresholds(%s of %s) = %v, want %v", tt.name, tt.spent, tt.available, got, tt.want)
+		}
+	}
+}
+
+func TestCheckBudgetAlerts(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	account := testAccount(t, db, user.ID, accountChecking, 100000)
+	food, rent := Category{Name: "Food", UserID: user.ID}, Category{Name: "Rent", UserID: user.ID}
+	for _, cat := range []*Category{&food, &rent} {
+		if err := db.Create(cat).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	budget := Budget{CategoryIDs: IDList{food.ID}, Amount: 10000, Criteria: budgetMonthly, AlertThresholds: Percentages{50, 100}, UserID: user.ID}
+	if err := db.Create(&budget).Error; err != nil {
+		t.Fatal(err)
+	}
+	var touches []budgetTouch
+	for _, tr := range []Transaction{
+		{Name: "groceries", Amount: -6000, Date: mustDate(t, "2024-03-05"), CategoryID: &food.ID},
+		{Name: "dinner", Amount: -5000, Date: mustDate(t, "2024-03-20"), CategoryID: &food.ID},
+		{Name: "groceries", Amount: -6000, Date: mustDate(t, "2024-04-02"), CategoryID: &food.ID},
+		{Name: "rent", Amount: -50000, Date: mustDate(t, "2024-04-01"), CategoryID: &rent.ID},
+	} {
+		tr.UserID, tr.AccountID, tr.Currency = user.ID, &account.ID, "IDR"
+		if err := db.Create(&tr).Error; err != nil {
+			t.Fatal(err)
+		}
+		touches = append(touches, transactionTouches(&tr)...)
+	}
+
+	// checking again, or a second day of the same period, raises nothing new
+	h.checkBudgetAlerts(user.ID, touches)
+	h.checkBudgetAlerts(user.ID, touches[1:])
+
+	var notifications []Notification
+	if err := db.Where("user_id = ?", user.ID).Order("period_start, threshold").Find(&notifications).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		period    string
+		threshold int
+		level     string
+	}{
+		{"2024-03-01", 50, notificationWarning},
+		{"2024-03-01", 100, notificationAlert},
+		{"2024-04-01", 50, notificationWarning},
+	}
+	if len(notifications) != len(want) {
+		t.Fatalf("got %d notifications, want %d: %+v", len(notifications), len(want), notifications)
+	}
+	for i, n := range notifications {
+		w := want[i]
+		if n.PeriodStart == nil || n.PeriodStart.Format("2006-01-02") != w.period || n.Threshold != w.threshold || n.Level != w.level {
+			t.Errorf("notification %d = %v %d%% %s, want %s %d%% %s", i, n.PeriodStart, n.Threshold, n.Level, w.period, w.threshold, w.level)
+		}
+		if n.BudgetID == nil || *n.BudgetID != budget.ID || n.Title != fmt.Sprintf("Food budget at %d%%", n.Threshold) {
+			t.Errorf("notification %d = %q for budget %v", i, n.Title, n.BudgetID)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultBudgetHistory = 12
+	maxBudgetHistory     = 120
+)
+
+// BudgetPeriod is one period of a budget. Available is the allocated amount
+// plus what rolled over from the previous period; Remaining, carried into
+// the next period by rollover budgets, is what is left of it.
+type BudgetPeriod struct {
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Allocated  Money     `json:"allocated"`
+	RolledOver Money     `json:"rolled_over"`
+	Available  Money     `json:"available"`
+	Spent      Money     `json:"spent"`
+	Remaining  Money     `json:"remaining"`
+}
+
+// Budget criteria, the kinds of period a budget covers.
+const (
+	budgetWeekly    = "weekly"
+	budgetMonthly   = "monthly"
+	budgetQuarterly = "quarterly"
+	budgetAnnual    = "annual"
+	// budgetPayday periods run from one AnchorDay of the month to the next.
+	budgetPayday = "payday"
+	// budgetCustom has a single period, from StartsOn to EndsOn.
+	budgetCustom = "custom"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+}
+
+// IDList is a set of row IDs, stored as a comma separated list.
+type IDList []uint
+
+func (l IDList) has(id uint) bool {
+	for _, v := range l {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (l IDList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]uint(l))
+}
+
+func (l IDList) Value() (driver.Value, error) {
+	parts := make([]string, len(l))
+	for i, id := range l {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (l *IDList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into IDList", src)
+	}
+	list := IDList{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", part)
+		}
+		list = append(list, uint(id))
+	}
+	*l = list
+	return nil
+}
+
+// unique drops repeated IDs, keeping the first of each.
+func (l IDList) unique() IDList {
+	seen := map[uint]bool{}
+	list := IDList{}
+	for _, id := range l {
+		if !seen[id] {
+			seen[id] = true
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+// counts reports whether spending on categoryID from accountID counts
+// towards b: b lists the category, if it lists any, and the account, if it
+// lists any.
+func (b *Budget) counts(categoryID, accountID *uint) bool {
+	if len(b.CategoryIDs) > 0 && (categoryID == nil || !b.CategoryIDs.has(*categoryID)) {
+		return false
+	}
+	if len(b.AccountIDs) > 0 && (accountID == nil || !b.AccountIDs.has(*accountID)) {
+		return false
+	}
+	return true
+}
+
+// budgetFilter reads the optional category_id and account_id query
+// parameters.
+func budgetFilter(c *gin.Context) (*uint, *uint, error) {
+	var ids [2]*uint
+	for i, name := range []string{"category_id", "account_id"} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s must be an id", name)
+		}
+		u := uint(id)
+		ids[i] = &u
+	}
+	return ids[0], ids[1], nil
+}
+
+// checkBudgetTargets makes sure the categories and accounts of b are the
+// user's.
+func (h *Handler) checkBudgetTargets(userID uint, b *Budget) error {
+	var count int64
+	if len(b.CategoryIDs) > 0 {
+		h.DB.Model(&Category{}).Where("id IN ? AND user_id = ?", []uint(b.CategoryIDs), userID).Count(&count)
+		if int(count) != len(b.CategoryIDs) {
+			return errors.New("category not found")
+		}
+	}
+	if len(b.AccountIDs) > 0 {
+		h.DB.Model(&Account{}).Where("id IN ? AND user_id = ?", []uint(b.AccountIDs), userID).Count(&count)
+		if int(count) != len(b.AccountIDs) {
+			return errors.New("account not found")
+		}
+	}
+	return nil
+}
+
+// normalize fills in defaults and rejects budgets without a category or
+// account, whose period is unknown or incomplete or whose alert thresholds
+// are invalid. The fields of other criteria are cleared.
+func (b *Budget) normalize() error {
+	b.Name = strings.TrimSpace(b.Name)
+	if b.CategoryID != nil {
+		b.CategoryIDs, b.CategoryID = IDList{*b.CategoryID}, nil
+	}
+	b.CategoryIDs, b.AccountIDs = b.CategoryIDs.unique(), b.AccountIDs.unique()
+	if len(b.CategoryIDs) == 0 && len(b.AccountIDs) == 0 {
+		return errors.New("category_ids or account_ids is required")
+	}
+	b.Criteria = strings.ToLower(strings.TrimSpace(b.Criteria))
+	if b.Criteria == "" {
+		b.Criteria = budgetMonthly
+	}
+	weekStart, anchorDay, startsOn, endsOn := b.WeekStart, b.AnchorDay, b.StartsOn, b.EndsOn
+	b.WeekStart, b.AnchorDay, b.StartsOn, b.EndsOn = "", 0, nil, nil
+	switch b.Criteria {
+	case budgetMonthly, budgetQuarterly, budgetAnnual:
+	case budgetWeekly:
+		b.WeekStart = strings.ToLower(strings.TrimSpace(weekStart))
+		if b.WeekStart == "" {
+			b.WeekStart = "monday"
+		}
+		if _, ok := weekdays[b.WeekStart]; !ok {
+			return errors.New("week_start must be a day of the week, e.g. monday")
+		}
+	case budgetPayday:
+		if anchorDay < 1 || anchorDay > 31 {
+			return errors.New("anchor_day must be between 1 and 31")
+		}
+		b.AnchorDay = anchorDay
+	case budgetCustom:
+		if startsOn == nil || endsOn == nil {
+			return errors.New("starts_on and ends_on are required for a custom budget")
+		}
+		start, end := dateOnly(*startsOn), dateOnly(*endsOn)
+		if end.Before(start) {
+			return errors.New("ends_on cannot be before starts_on")
+		}
+		b.StartsOn, b.EndsOn = &start, &end
+	default:
+		return errors.New("criteria must be weekly, monthly, quarterly, annual, payday or custom")
+	}
+	return normalizeThresholds(b)
+}
+
+// budgetPeriod returns the period of b containing day, as dates with an
+// exclusive end. A custom budget has one period whatever the day.
+func budgetPeriod(b *Budget, day time.Time) (time.Time, time.Time) {
+	day = dateOnly(day)
+	switch b.Criteria {
+	case budgetWeekly:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) - int(weekdays[b.WeekStart]) + 7) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case budgetQuarterly:
+		start := time.Date(day.Year(), (day.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0)
+	case budgetAnnual:
+		start := time.Date(day.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0)
+	case budgetPayday:
+		start := payday(day.Year(), day.Month(), b.AnchorDay)
+		if day.Before(start) {
+			start = payday(day.Year(), day.Month()-1, b.AnchorDay)
+		}
+		return start, payday(start.Year(), start.Month()+1, b.AnchorDay)
+	case budgetCustom:
+		if b.StartsOn != nil && b.EndsOn != nil {
+			return dateOnly(*b.StartsOn), dateOnly(*b.EndsOn).AddDate(0, 0, 1)
+		}
+	}
+	start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// payday is the anchor day of a month, or its last day in shorter months.
+func payday(year int, month time.Month, anchor int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	if last := daysIn(first.Year(), first.Month()); anchor > last {
+		anchor = last
+	}
+	return first.AddDate(0, 0, anchor-1)
+}
+
+// budgetPeriods computes the periods of b from the one containing from up to
+// the one containing until, always at least one. Rollover budgets carry each
+// period's remaining, positive or negative, into the next one, starting with
+// the period the budget was created in; computing a later period therefore
+// starts there. Spending is converted into the base currency of cv.
+func (h *Handler) budgetPeriods(cv *currencyConverter, userID uint, b *Budget, from, until time.Time) ([]BudgetPeriod, error) {
+	first, _ := budgetPeriod(b, b.CreatedAt)
+	if b.Rollover && first.Before(from) {
+		from = first
+	}
+	var periods []BudgetPeriod
+	start, end := budgetPeriod(b, from)
+	for {
+		periods = append(periods, BudgetPeriod{Start: start, End: end, Allocated: b.Amount})
+		next, nextEnd := budgetPeriod(b, end)
+		// a custom budget's only period comes back
+		if !next.After(start) || next.After(dateOnly(until)) {
+			break
+		}
+		start, end = next, nextEnd
+	}
+
+	var rows []struct {
+		Date     time.Time
+		Currency string
+		Total    Money
+	}
+	query := h.categoryLines(userID).
+		Where(lineAmount+" < 0 AND t.date >= ? AND t.date < ?", periods[0].Start, periods[len(periods)-1].End)
+	if len(b.CategoryIDs) > 0 {
+		query = query.Where(lineCategory+" IN ?", []uint(b.CategoryIDs))
+	}
+	if len(b.AccountIDs) > 0 {
+		query = query.Where("t.account_id IN ?", []uint(b.AccountIDs))
+	}
+	err := query.Select("t.date AS date, t.currency AS currency, COALESCE(SUM(ABS(" + lineAmount + ")), 0) AS total").
+		Group("t.date, t.currency").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	totals := make([][]currencyTotal, len(periods))
+	for _, r := range rows {
+		date := dateOnly(r.Date)
+		i := sort.Search(len(periods), func(i int) bool { return periods[i].End.After(date) })
+		if i < len(periods) {
+			totals[i] = append(totals[i], currencyTotal{Currency: r.Currency, Total: r.Total})
+		}
+	}
+	for i := range periods {
+		p := &periods[i]
+		if p.Spent, _, err = cv.Sum(totals[i]); err != nil {
+			return nil, err
+		}
+		if b.Rollover && i > 0 && !p.Start.Before(first) {
+			p.RolledOver = periods[i-1].Remaining
+		}
+		p.Available = p.Allocated + p.RolledOver
+		p.Remaining = p.Available - p.Spent
+	}
+	return periods, nil
+}
+
+// currentBudgetPeriod is the period of b containing day.
+func (h *Handler) currentBudgetPeriod(cv *currencyConverter, userID uint, b *Budget, day time.Time) (BudgetPeriod, error) {
+	periods, err := h.budgetPeriods(cv, userID, b, day, day)
+	if err != nil {
+		return BudgetPeriod{}, err
+	}
+	return periods[len(periods)-1], nil
+}
+
+// GetBudgetHistory lists the last periods (default 12) of a budget, up to
+// the current one, with the allocated, rolled over, available and spent
+// amounts of each.
+func (h *Handler) GetBudgetHistory(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var budget Budget
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&budget).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Budget not found"})
+		return
+	}
+	count := defaultBudgetHistory
+	if v := c.Query("periods"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxBudgetHistory {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("periods must be between 1 and %d", maxBudgetHistory)})
+			return
+		}
+		count = n
+	}
+	now := time.Now()
+	from, _ := budgetPeriod(&budget, now)
+	for i := 1; i < count && budget.Criteria != budgetCustom; i++ {
+		from, _ = budgetPeriod(&budget, from.AddDate(0, 0, -1))
+	}
+	cv := h.newConverter(userID, now)
+	periods, err := h.budgetPeriods(cv, userID, &budget, from, now)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if len(periods) > count {
+		periods = periods[len(periods)-count:]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"budget":   budget,
+		"currency": cv.base,
+		"periods":  periods,
+		"rates":    cv.Applied(),
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetPeriod(t *testing.T) {
+	startsOn, endsOn := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		budget Budget
+		day    string
+		start  string
+		end    string
+	}{
+		{"monthly", Budget{Criteria: budgetMonthly}, "2024-02-15", "2024-02-01", "2024-03-01"},
+		{"monthly last day", Budget{Criteria: budgetMonthly}, "2024-12-31", "2024-12-01", "2025-01-01"},
+		{"weekly from monday", Budget{Criteria: budgetWeekly, WeekStart: "monday"}, "2024-03-06", "2024-03-04", "2024-03-11"},
+		{"weekly on the start day", Budget{Criteria: budgetWeekly, WeekStart: "monday"}, "2024-03-04", "2024-03-04", "2024-03-11"},
+		{"weekly from sunday", Budget{Criteria: budgetWeekly, WeekStart: "sunday"}, "2024-03-09", "2024-03-03", "2024-03-10"},
+		{"weekly across the year", Budget{Criteria: budgetWeekly, WeekStart: "monday"}, "2025-01-01", "2024-12-30", "2025-01-06"},
+		{"quarterly", Budget{Criteria: budgetQuarterly}, "2024-05-31", "2024-04-01", "2024-07-01"},
+		{"quarterly last quarter", Budget{Criteria: budgetQuarterly}, "2024-12-01", "2024-10-01", "2025-01-01"},
+		{"annual", Budget{Criteria: budgetAnnual}, "2024-07-04", "2024-01-01", "2025-01-01"},
+		{"payday after the anchor", Budget{Criteria: budgetPayday, AnchorDay: 25}, "2024-03-28", "2024-03-25", "2024-04-25"},
+		{"payday before the anchor", Budget{Criteria: budgetPayday, AnchorDay: 25}, "2024-03-10", "2024-02-25", "2024-03-25"},
+		{"payday across the year", Budget{Criteria: budgetPayday, AnchorDay: 25}, "2025-01-05", "2024-12-25", "2025-01-25"},
+		// the anchor falls back to the last day of shorter months
+		{"payday in february", Budget{Criteria: budgetPayday, AnchorDay: 31}, "2024-02-29", "2024-02-29", "2024-03-31"},
+		{"payday before february", Budget{Criteria: budgetPayday, AnchorDay: 31}, "2024-02-28", "2024-01-31", "2024-02-29"},
+		{"payday in april", Budget{Criteria: budgetPayday, AnchorDay: 31}, "2024-04-30", "2024-04-30", "2024-05-31"},
+		{"custom", Budget{Criteria: budgetCustom, StartsOn: &startsOn, EndsOn: &endsOn}, "2023-01-01", "2024-03-10", "2024-04-21"},
+	}
+	for _, tt := range tests {
+		day := mustDate(t, tt.day).Add(15 * time.Hour)
+		start, end := budgetPeriod(&tt.budget, day)
+		if !start.Equal(mustDate(t, tt.start)) || !end.Equal(mustDate(t, tt.end)) {
+			t.Errorf("%s: budgetPeriod(%s) = %s to %s, want %s to %s", tt.name, tt.day,
+				start.Format("2006-01-02"), end.Format("2006-01-02"), tt.start, tt.end)
+		}
+	}
+}
+
+func TestBudgetNormalize(t *testing.T) {
+	ids := IDList{1, 1}
+	tests := []struct {
+		name    string
+		budget  Budget
+		wantErr bool
+	}{
+		{"monthly", Budget{CategoryIDs: ids, Criteria: budgetMonthly}, false},
+		{"no category or account", Budget{Criteria: budgetMonthly}, true},
+		{"default week start", Budget{CategoryIDs: ids, Criteria: budgetWeekly}, false},
+		{"unknown week start", Budget{CategoryIDs: ids, Criteria: budgetWeekly, WeekStart: "someday"}, true},
+		{"payday without anchor", Budget{CategoryIDs: ids, Criteria: budgetPayday}, true},
+		{"payday anchor out of range", Budget{CategoryIDs: ids, Criteria: budgetPayday, AnchorDay: 32}, true},
+		{"custom without dates", Budget{CategoryIDs: ids, Criteria: budgetCustom}, true},
+		{"unknown criteria", Budget{CategoryIDs: ids, Criteria: "fortnightly"}, true},
+	}
+	for _, tt := range tests {
+		b := tt.budget
+		err := b.normalize()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: normalize() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	b := Budget{CategoryIDs: ids, Criteria: " Weekly ", AnchorDay: 5}
+	if err := b.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if b.Criteria != budgetWeekly || b.WeekStart != "monday" || b.AnchorDay != 0 || len(b.CategoryIDs) != 1 {
+		t.Errorf("normalize() = %+v, want a weekly budget from monday on one category", b)
+	}
+}
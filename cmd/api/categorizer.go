@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// categorizerHistory is how many of the latest categorized transactions
+	// the classifier learns from.
+	categorizerHistory = 5000
+	// importSuggestionConfidence is the confidence a suggestion needs to
+	// categorize an imported line that no rule categorized.
+	importSuggestionConfidence = 0.6
+	maxSuggestions             = 3
+)
+
+// categorizer is a multinomial naive Bayes classifier that learns the
+// category of a transaction from the words of its name and its sign.
+type categorizer struct {
+	docs   map[uint]int
+	tokens map[uint]map[string]int
+	totals map[uint]int
+	vocab  map[string]bool
+	total  int
+}
+
+// CategorySuggestion is a category the classifier proposes, with the
+// probability it gives it.
+type CategorySuggestion struct {
+	CategoryID uint    `json:"category_id"`
+	Confidence float64 `json:"confidence"`
+}
+
+// nameTokens splits a transaction name into lower case words, leaving out
+// single characters and numbers, which are mostly references and dates.
+func nameTokens(name string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < 2 || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			continue
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// features are the tokens of name plus a token for the sign of amount.
+func features(name string, amount Money) []string {
+	sign := "sign:income"
+	if amount < 0 {
+		sign = "sign:expense"
+	}
+	return append(nameTokens(name), sign)
+}
+
+// trainCategorizer learns from the user's latest categorized transactions
+// that are not split.
+func trainCategorizer(db *gorm.DB, userID uint) (*categorizer, error) {
+	var rows []struct {
+		Name       string
+		Amount     Money
+		CategoryID uint
+	}
+	err := db.Model(&Transaction{}).
+		Where("user_id = ? AND category_id IS NOT NULL", userID).
+		Where("NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = transactions.id)").
+		Order("date DESC, id DESC").Limit(categorizerHistory).
+		Select("name, amount, category_id").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	m := &categorizer{
+		docs:   map[uint]int{},
+		tokens: map[uint]map[string]int{},
+		totals: map[uint]int{},
+		vocab:  map[string]bool{},
+	}
+	for _, row := range rows {
+		m.learn(row.Name, row.Amount, row.CategoryID)
+	}
+	return m, nil
+}
+
+func (m *categorizer) learn(name string, amount Money, categoryID uint) {
+	m.docs[categoryID]++
+	m.total++
+	if m.tokens[categoryID] == nil {
+		m.tokens[categoryID] = map[string]int{}
+	}
+	for _, token := range features(name, amount) {
+		m.tokens[categoryID][token]++
+		m.totals[categoryID]++
+		m.vocab[token] = true
+	}
+}
+
+// suggest ranks the categories for a transaction by probability, most likely
+// first. It suggests nothing when no word of the name has been seen before,
+// since the sign alone says little about the category.
+func (m *categorizer) suggest(name string, amount Money) []CategorySuggestion {
+	tokens := features(name, amount)
+	known := false
+	for _, token := range nameTokens(name) {
+		known = known || m.vocab[token]
+	}
+	if !known {
+		return nil
+	}
+	// log P(category) + sum of log P(token | category), add-one smoothed
+	logs := map[uint]float64{}
+	best := math.Inf(-1)
+	vocab := float64(len(m.vocab))
+	for categoryID, docs := range m.docs {
+		p := math.Log(float64(docs) / float64(m.total))
+		for _, token := range tokens {
+			p += math.Log(float64(m.tokens[categoryID][token]+1) / (float64(m.totals[categoryID]) + vocab))
+		}
+		logs[categoryID] = p
+		best = math.Max(best, p)
+	}
+	var sum float64
+	for _, p := range logs {
+		sum += math.Exp(p - best)
+	}
+	suggestions := make([]CategorySuggestion, 0, len(logs))
+	for categoryID, p := range logs {
+		suggestions = append(suggestions, CategorySuggestion{categoryID, math.Exp(p-best) / sum})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].CategoryID < suggestions[j].CategoryID
+	})
+	return suggestions
+}
+
+// SuggestCategory proposes categories for a transaction named name, learned
+// from how the user categorized earlier transactions. The optional amount
+// tells income from expense; without it the transaction is taken as an
+// expense.
+func (h *Handler) SuggestCategory(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	var amount Money = -1
+	if v := c.Query("amount"); v != "" {
+		var err error
+		if amount, err = ParseMoney(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amount: " + err.Error()})
+			return
+		}
+	}
+	m, err := trainCategorizer(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	suggestions := m.suggest(name, amount)
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	response := gin.H{"category_id": nil, "category": nil, "confidence": 0, "suggestions": []CategorySuggestion{}}
+	if len(suggestions) > 0 {
+		var category Category
+		h.DB.Where("id = ? AND user_id = ?", suggestions[0].CategoryID, userID).First(&category)
+		response = gin.H{
+			"category_id": suggestions[0].CategoryID,
+			"category":    category,
+			"confidence":  suggestions[0].Confidence,
+			"suggestions": suggestions,
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNameTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"POS 1234 STARBUCKS JKT", []string{"pos", "starbucks", "jkt"}},
+		{"Amazon.com*A1B2", []string{"amazon", "com", "a1b2"}},
+		{"Café Müller", []string{"café", "müller"}},
+		{"X 12/03 Y", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := nameTokens(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nameTokens(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func newTestCategorizer() *categorizer {
+	m := &categorizer{
+		docs:   map[uint]int{},
+		tokens: map[uint]map[string]int{},
+		totals: map[uint]int{},
+		vocab:  map[string]bool{},
+	}
+	history := []struct {
+		name       string
+		amount     Money
+		categoryID uint
+	}{
+		{"Starbucks 123", -450, 1},
+		{"STARBUCKS JKT", -500, 1},
+		{"Corner Coffee", -300, 1},
+		{"Shell Station", -4000, 2},
+		{"Shell 99", -3500, 2},
+		{"Salary ACME", 500000, 3},
+	}
+	for _, h := range history {
+		m.learn(h.name, h.amount, h.categoryID)
+	}
+	return m
+}
+
+func TestCategorizerSuggest(t *testing.T) {
+	m := newTestCategorizer()
+	tests := []struct {
+		name   string
+		amount Money
+		want   uint
+	}{
+		{"starbucks 555", -450, 1},
+		{"Coffee to go", -250, 1},
+		{"SHELL 12", -3000, 2},
+		{"ACME salary March", 510000, 3},
+	}
+	for _, tt := range tests {
+		suggestions := m.suggest(tt.name, tt.amount)
+		if len(suggestions) != 3 {
+			t.Errorf("suggest(%q) = %v, want every category ranked", tt.name, suggestions)
+			continue
+		}
+		if suggestions[0].CategoryID != tt.want {
+			t.Errorf("suggest(%q) = category %d, want %d", tt.name, suggestions[0].CategoryID, tt.want)
+		}
+		sum := 0.0
+		for i, s := range suggestions {
+			sum += s.Confidence
+			if i > 0 && s.Confidence > suggestions[i-1].Confidence {
+				t.Errorf("suggest(%q) is not ordered by confidence: %v", tt.name, suggestions)
+			}
+		}
+		if sum < 0.999 || sum > 1.001 {
+			t.Errorf("suggest(%q) confidences add up to %v, want 1", tt.name, sum)
+		}
+	}
+
+	// the sign alone suggests nothing
+	for _, name := range []string{"Unknown merchant", "12345", ""} {
+		if suggestions := m.suggest(name, -100); suggestions != nil {
+			t.Errorf("suggest(%q) = %v, want nothing", name, suggestions)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultCurrency = "IDR"
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ExchangeRate stores how many units of ToCurrency one unit of FromCurrency
+// was worth on Date.
+type ExchangeRate struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	FromCurrency string    `gorm:"size:3;not null" json:"from_currency"`
+	ToCurrency   string    `gorm:"size:3;not null" json:"to_currency"`
+	Rate         string    `gorm:"type:decimal(18,8);not null" json:"rate"`
+	Date         time.Time `gorm:"type:date;not null" json:"date"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AppliedRate reports the rate used to convert a total into the base currency.
+type AppliedRate struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Rate string    `json:"rate"`
+	Date time.Time `json:"date"`
+}
+
+// currencyTotal is one row of a SUM(amount) ... GROUP BY currency query.
+type currencyTotal struct {
+	Currency string `json:"currency"`
+	Total    Money  `json:"total"`
+}
+
+func normalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !currencyPattern.MatchString(code) {
+		return "", fmt.Errorf("invalid currency code %q", code)
+	}
+	return code, nil
+}
+
+func parseRate(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q", s)
+	}
+	return r, nil
+}
+
+// baseCurrency returns the currency the user wants totals reported in.
+func (h *Handler) baseCurrency(userID uint) string {
+	var user User
+	if err := h.DB.Select("base_currency").First(&user, userID).Error; err != nil || user.BaseCurrency == "" {
+		return defaultCurrency
+	}
+	return user.BaseCurrency
+}
+
+// transactionCurrency resolves the currency of a transaction booked against
+// accountID. Transactions always share their account's currency; without an
+// account they default to the user's base currency.
+func (h *Handler) transactionCurrency(tx *gorm.DB, userID uint, accountID *uint, requested string) (string, error) {
+	currency := ""
+	if requested != "" {
+		code, err := normalizeCurrency(requested)
+		if err != nil {
+			return "", err
+		}
+		currency = code
+	}
+	if accountID == nil {
+		if currency == "" {
+			currency = h.baseCurrency(userID)
+		}
+		return currency, nil
+	}
+	var account Account
+	if err := tx.Where("id = ? AND user_id = ?", *accountID, userID).First(&account).Error; err != nil {
+		return "", errors.New("account not found")
+	}
+	if currency != "" && currency != account.Currency {
+		return "", fmt.Errorf("currency %s does not match account currency %s", currency, account.Currency)
+	}
+	return account.Currency, nil
+}
+
+// currencyConverter converts amounts into a user's base currency using the
+// latest stored rate on or before a given day. A missing direct rate falls
+// back to the inverse of the opposite pair.
+type currencyConverter struct {
+	db     *gorm.DB
+	userID uint
+	base   string
+	on     time.Time
+	rates  map[string]*big.Rat
+	used   map[string]AppliedRate
+}
+
+func (h *Handler) newConverter(userID uint, on time.Time) *currencyConverter {
+	return &currencyConverter{
+		db:     h.DB,
+		userID: userID,
+		base:   h.baseCurrency(userID),
+		on:     on,
+		rates:  map[string]*big.Rat{},
+		used:   map[string]AppliedRate{},
+	}
+}
+
+func (cv *currencyConverter) rate(from string) (*big.Rat, AppliedRate, error) {
+	if r, ok := cv.rates[from]; ok {
+		return r, cv.used[from], nil
+	}
+	var er ExchangeRate
+	err := cv.db.Where("user_id = ? AND from_currency = ? AND to_currency = ? AND date <= ?", cv.userID, from, cv.base, cv.on).
+		Order("date DESC").First(&er).Error
+	inverse := false
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		inverse = true
+		err = cv.db.Where("user_id = ? AND from_currency = ? AND to_currency = ? AND date <= ?", cv.userID, cv.base, from, cv.on).
+			Order("date DESC").First(&er).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, AppliedRate{}, fmt.Errorf("no exchange rate from %s to %s on or before %s", from, cv.base, cv.on.Format("2006-01-02"))
+	}
+	if err != nil {
+		return nil, AppliedRate{}, err
+	}
+	r, err := parseRate(er.Rate)
+	if err != nil {
+		return nil, AppliedRate{}, err
+	}
+	if inverse {
+		r.Inv(r)
+	}
+	applied := AppliedRate{From: from, To: cv.base, Rate: r.FloatString(8), Date: er.Date}
+	cv.rates[from] = r
+	cv.used[from] = applied
+	return r, applied, nil
+}
+
+// Convert converts amount from the given currency into the base currency.
+func (cv *currencyConverter) Convert(amount Money, from string) (Money, error) {
+	if from == "" || from == cv.base {
+		return amount, nil
+	}
+	r, _, err := cv.rate(from)
+	if err != nil {
+		return 0, err
+	}
+	return amount.Mul(r), nil
+}
+
+// FromBase converts an amount in the base currency into currency, the
+// inverse of Convert.
+func (cv *currencyConverter) FromBase(amount Money, currency string) (Money, error) {
+	if currency == "" || currency == cv.base {
+		return amount, nil
+	}
+	r, _, err := cv.rate(currency)
+	if err != nil {
+		return 0, err
+	}
+	return amount.Mul(new(big.Rat).Inv(r)), nil
+}
+
+// Sum converts and adds per-currency totals, returning the rates it applied.
+func (cv *currencyConverter) Sum(totals []currencyTotal) (Money, []AppliedRate, error) {
+	var sum Money
+	applied := []AppliedRate{}
+	for _, t := range totals {
+		if t.Currency == "" || t.Currency == cv.base {
+			sum += t.Total
+			continue
+		}
+		r, ar, err := cv.rate(t.Currency)
+		if err != nil {
+			return 0, nil, err
+		}
+		sum += t.Total.Mul(r)
+		applied = append(applied, ar)
+	}
+	return sum, applied, nil
+}
+
+// Applied lists every rate the converter has used so far.
+func (cv *currencyConverter) Applied() []AppliedRate {
+	applied := make([]AppliedRate, 0, len(cv.used))
+	for _, ar := range cv.used {
+		applied = append(applied, ar)
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i].From < applied[j].From })
+	return applied
+}
+
+func (h *Handler) GetExchangeRates(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var rates []ExchangeRate
+	h.DB.Where("user_id = ?", userID).Order("date DESC, from_currency, to_currency").Find(&rates)
+	c.JSON(http.StatusOK, rates)
+}
+
+// ImportExchangeRates reads a CSV upload with the columns date (YYYY-MM-DD),
+// from, to and rate. A header row is optional. Rates for the same pair and
+// day replace the stored value.
+func (h *Handler) ImportExchangeRates(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing CSV file"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+	var rates []ExchangeRate
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "date") {
+			continue
+		}
+		rate, err := parseExchangeRateRecord(record)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("line %d: %v", line, err)})
+			return
+		}
+		rate.UserID = userID
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no exchange rates in file"})
+		return
+	}
+	err = h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "from_currency"}, {Name: "to_currency"}, {Name: "date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).CreateInBatches(&rates, 500).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"imported": len(rates)})
+}
+
+func parseExchangeRateRecord(record []string) (ExchangeRate, error) {
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+	if err != nil {
+		return ExchangeRate{}, fmt.Errorf("invalid date %q", record[0])
+	}
+	from, err := normalizeCurrency(record[1])
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	to, err := normalizeCurrency(record[2])
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	if from == to {
+		return ExchangeRate{}, errors.New("from and to currency are the same")
+	}
+	r, err := parseRate(record[3])
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	return ExchangeRate{FromCurrency: from, ToCurrency: to, Rate: r.FloatString(8), Date: date}, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testDB opens the PostgreSQL database named by TEST_DATABASE_URL in a schema
+// of its own, runs the migrations into it and drops it when the test ends.
+// Tests that need a database are skipped without one.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	config := &gorm.Config{Logger: logger.Discard}
+	admin, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	// every pooled connection has to see the schema, so it goes into the DSN
+	if strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+	db, err := gorm.Open(postgres.Open(dsn), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		sql, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Exec(string(sql)).Error; err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+	return db
+}
+
+// testUser creates a user with base currency IDR.
+func testUser(t *testing.T, db *gorm.DB) User {
+	t.Helper()
+	user := User{Name: "Test", Email: fmt.Sprintf("test%d@example.com", time.Now().UnixNano()), Password: "x", BaseCurrency: "IDR"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// testAccount creates an account of userID with the given type and balance in
+// IDR.
+func testAccount(t *testing.T, db *gorm.DB, userID uint, accountType string, amount Money) Account {
+	t.Helper()
+	account := Account{BankName: accountType, Type: accountType, Amount: amount, Currency: "IDR", UserID: userID}
+	if err := db.Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	return account
+}
+
+// serve runs handler for userID with the route params and JSON body given,
+// target carrying any query string, and returns the response.
+func serve(handler gin.HandlerFunc, userID uint, target string, params gin.Params, body interface{}) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	c.Request = httptest.NewRequest("POST", target, bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	c.Set("user_id", userID)
+	handler(c)
+	c.Writer.WriteHeaderNow()
+	return w
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultDuplicateDays       = 3
+	defaultDuplicateTolerance  = 1.0 // percent of the amount
+	defaultDuplicateSimilarity = 0.5
+	defaultDuplicateLookback   = 180 // days scanned without from
+	maxDuplicatePairs          = 500
+)
+
+// DuplicatePair is two transactions that look like the same one booked
+// twice. KeepID suggests which to keep: the one the bank identified, else
+// the older one.
+type DuplicatePair struct {
+	A                Transaction `json:"a"`
+	B                Transaction `json:"b"`
+	Score            float64     `json:"score"`
+	NameSimilarity   float64     `json:"name_similarity"`
+	DayDifference    int         `json:"day_difference"`
+	AmountDifference Money       `json:"amount_difference"`
+	KeepID           uint        `json:"keep_id"`
+}
+
+// duplicateOptions bound how far apart two transactions may be to count as
+// duplicates.
+type duplicateOptions struct {
+	days       int
+	tolerance  float64
+	similarity float64
+}
+
+// comparableName lower cases name and keeps only its letters and digits.
+func comparableName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// levenshtein is the edit distance between a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// nameSimilarity scores how alike two transaction names are from 0 to 1:
+// the better of the share of words they have in common and how few edits
+// turn one into the other. A statement name such as "POS 1234 STARBUCKS
+// JKT" and a typed "Starbucks" share their words; "Starbuck" and
+// "Starbucks" are one edit apart.
+func nameSimilarity(a, b string) float64 {
+	ta, tb := map[string]bool{}, map[string]bool{}
+	for _, token := range nameTokens(a) {
+		ta[token] = true
+	}
+	for _, token := range nameTokens(b) {
+		tb[token] = true
+	}
+	words := 0.0
+	if len(ta) > 0 && len(tb) > 0 {
+		common := 0
+		for token := range ta {
+			if tb[token] {
+				common++
+			}
+		}
+		// shared words over the shorter name, so extra statement noise is not held against it
+		words = float64(common) / float64(min(len(ta), len(tb)))
+	}
+	ra, rb := []rune(comparableName(a)), []rune(comparableName(b))
+	edits := 0.0
+	if longest := max(len(ra), len(rb)); longest > 0 {
+		edits = 1 - float64(levenshtein(ra, rb))/float64(longest)
+	}
+	return math.Max(words, edits)
+}
+
+// duplicatePair scores a and b as duplicates, or reports that they are not:
+// they must be booked to the same account with the same sign, within
+// o.days of each other, with amounts within o.tolerance percent and names at
+// least o.similarity alike. Two lines the bank gave different FITIDs are
+// distinct.
+func duplicatePair(a, b *Transaction, o duplicateOptions) (DuplicatePair, bool) {
+	if (a.AccountID == nil) != (b.AccountID == nil) || (a.AccountID != nil && *a.AccountID != *b.AccountID) {
+		return DuplicatePair{}, false
+	}
+	if (a.Amount < 0) != (b.Amount < 0) {
+		return DuplicatePair{}, false
+	}
+	if a.FITID != nil && b.FITID != nil && *a.FITID != *b.FITID {
+		return DuplicatePair{}, false
+	}
+	days := int(math.Abs(dateOnly(a.Date).Sub(dateOnly(b.Date)).Hours() / 24))
+	if days > o.days {
+		return DuplicatePair{}, false
+	}
+	diff := (a.Amount - b.Amount).Abs()
+	allowed := float64(max(a.Amount.Abs(), b.Amount.Abs())) * o.tolerance / 100
+	if float64(diff) > allowed {
+		return DuplicatePair{}, false
+	}
+	similarity := nameSimilarity(a.Name, b.Name)
+	if similarity < o.similarity {
+		return DuplicatePair{}, false
+	}
+	amountScore := 1.0
+	if allowed > 0 {
+		amountScore = 1 - float64(diff)/allowed
+	}
+	score := 0.5*similarity + 0.25*(1-float64(days)/float64(o.days+1)) + 0.25*amountScore
+	keep := a
+	if (b.FITID != nil && a.FITID == nil) || ((a.FITID == nil) == (b.FITID == nil) && b.CreatedAt.Before(a.CreatedAt)) {
+		keep = b
+	}
+	return DuplicatePair{
+		A:                *a,
+		B:                *b,
+		Score:            math.Round(score*1000) / 1000,
+		NameSimilarity:   math.Round(similarity*1000) / 1000,
+		DayDifference:    days,
+		AmountDifference: diff,
+		KeepID:           keep.ID,
+	}, true
+}
+
+// findDuplicates pairs up the transactions that look alike. transactions
+// must be ordered by date.
+func findDuplicates(transactions []Transaction, o duplicateOptions) []DuplicatePair {
+	pairs := []DuplicatePair{}
+	for i := range transactions {
+		for j := i + 1; j < len(transactions); j++ {
+			if dateOnly(transactions[j].Date).Sub(dateOnly(transactions[i].Date)) > time.Duration(o.days)*24*time.Hour {
+				break
+			}
+			if pair, ok := duplicatePair(&transactions[i], &transactions[j], o); ok {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Score > pairs[j].Score })
+	return pairs
+}
+
+func parseDuplicateOptions(c *gin.Context) (duplicateOptions, error) {
+	o := duplicateOptions{days: defaultDuplicateDays, tolerance: defaultDuplicateTolerance, similarity: defaultDuplicateSimilarity}
+	if v := c.Query("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 || days > 31 {
+			return o, errors.New("days must be between 0 and 31")
+		}
+		o.days = days
+	}
+	if v := c.Query("amount_tolerance"); v != "" {
+		tolerance, err := strconv.ParseFloat(v, 64)
+		if err != nil || tolerance < 0 || tolerance > 50 {
+			return o, errors.New("amount_tolerance must be a percentage between 0 and 50")
+		}
+		o.tolerance = tolerance
+	}
+	if v := c.Query("min_similarity"); v != "" {
+		similarity, err := strconv.ParseFloat(v, 64)
+		if err != nil || similarity < 0 || similarity > 1 {
+			return o, errors.New("min_similarity must be between 0 and 1")
+		}
+		o.similarity = similarity
+	}
+	return o, nil
+}
+
+// GetDuplicateTransactions lists pairs of transactions that look like
+// duplicates, most alike first. Two transactions are candidates when they
+// are booked to the same account with the same sign within days (default 3)
+// of each other, their amounts differ by at most amount_tolerance percent
+// (default 1) and their names are at least min_similarity (0 to 1, default
+// 0.5) alike. from and to (YYYY-MM-DD) limit the dates scanned, by default
+// the last 180 days, and account_id the account.
+func (h *Handler) GetDuplicateTransactions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	o, err := parseDuplicateOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from := dateOnly(time.Now()).AddDate(0, 0, -defaultDuplicateLookback)
+	query := h.DB.Where("user_id = ?", userID)
+	if v := c.Query("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+	query = query.Where("date >= ?", from)
+	if v := c.Query("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date (YYYY-MM-DD)"})
+			return
+		}
+		query = query.Where("date <= ?", to)
+	}
+	if v := c.Query("account_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account_id"})
+			return
+		}
+		query = query.Where("account_id = ?", id)
+	}
+	var transactions []Transaction
+	if err := query.Preload("Category").Preload("Account").Order("date, id").Find(&transactions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	pairs := findDuplicates(transactions, o)
+	truncated := len(pairs) > maxDuplicatePairs
+	if truncated {
+		pairs = pairs[:maxDuplicatePairs]
+	}
+	c.JSON(http.StatusOK, gin.H{"pairs": pairs, "truncated": truncated})
+}
+
+// MergeTransactions merges the duplicate remove_id into keep_id. The kept
+// transaction keeps its name, amount and date and takes the category, value
+// date, bank FITID and tags it lacks from the removed one; scheduled
+// postings of the removed one point to the kept one. The removed amount is
+// taken off its account balance in the same database transaction.
+// Reconciled transactions cannot be merged.
+func (h *Handler) MergeTransactions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		KeepID   uint `json:"keep_id" binding:"required"`
+		RemoveID uint `json:"remove_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.KeepID == req.RemoveID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keep_id and remove_id must differ"})
+		return
+	}
+	var keep, removed Transaction
+	errNotFound := errors.New("Transaction not found")
+	errAccounts := errors.New("only transactions of the same account can be merged")
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var rows []Transaction
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ? AND user_id = ?", []uint{req.KeepID, req.RemoveID}, userID).
+			Order("id").Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) != 2 {
+			return errNotFound
+		}
+		keep, removed = rows[0], rows[1]
+		if keep.ID != req.KeepID {
+			keep, removed = removed, keep
+		}
+		if (keep.AccountID == nil) != (removed.AccountID == nil) || (keep.AccountID != nil && *keep.AccountID != *removed.AccountID) {
+			return errAccounts
+		}
+		if keep.Status == transactionReconciled || removed.Status == transactionReconciled {
+			return errReconciled
+		}
+		// the kept transaction is cleared when either was
+		if removed.Status == transactionCleared {
+			keep.Status = transactionCleared
+		}
+		if err := tx.Where("transaction_id = ?", keep.ID).Find(&keep.Splits).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("transaction_id = ?", removed.ID).Find(&removed.Splits).Error; err != nil {
+			return err
+		}
+		if keep.CategoryID == nil && len(keep.Splits) == 0 && len(removed.Splits) == 0 {
+			keep.CategoryID = removed.CategoryID
+		}
+		if keep.ValueDate == nil {
+			keep.ValueDate = removed.ValueDate
+		}
+		if keep.FITID == nil {
+			keep.FITID = removed.FITID
+		}
+		for _, tag := range removed.Tags {
+			if !keep.Tags.has(tag) {
+				keep.Tags = append(keep.Tags, tag)
+			}
+		}
+		err = tx.Model(&ScheduledOccurrence{}).Where("transaction_id = ?", removed.ID).
+			Update("transaction_id", keep.ID).Error
+		if err != nil {
+			return err
+		}
+		// the FITID is unique per account, so the removed line goes first
+		if err := tx.Delete(&Transaction{}, removed.ID).Error; err != nil {
+			return err
+		}
+		if err := adjustBalance(tx, userID, removed.AccountID, -removed.Amount); err != nil {
+			return err
+		}
+		return tx.Model(&Transaction{}).Where("id = ?", keep.ID).Updates(map[string]interface{}{
+			"category_id": keep.CategoryID,
+			"value_date":  keep.ValueDate,
+			"fitid":       keep.FITID,
+			"tags":        keep.Tags,
+			"status":      keep.Status,
+		}).Error
+	})
+	switch {
+	case errors.Is(err, errNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errAccounts), errors.Is(err, errReconciled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.checkBudgetAlerts(userID, transactionTouches(&keep))
+	h.DB.Preload("Category").Preload("Account").Preload("Splits.Category").First(&keep, keep.ID)
+	c.JSON(http.StatusOK, gin.H{"transaction": keep, "removed_id": removed.ID})
+}
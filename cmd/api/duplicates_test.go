@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"Starbucks", "Starbucks", 1},
+		{"STARBUCKS", "starbucks", 1},
+		{"POS 1234 STARBUCKS JKT", "Starbucks", 1},
+		{"Starbuck", "Starbucks", 1 - 1.0/9},
+		{"Grab", "Starbucks", 1 - 7.0/9},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := nameSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("nameSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicatePair(t *testing.T) {
+	account, other := uint(1), uint(2)
+	fitA, fitB := "A", "B"
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	older := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	base := Transaction{ID: 1, Name: "Starbucks", Amount: -500, Date: day, AccountID: &account, CreatedAt: older}
+	o := duplicateOptions{days: 3, tolerance: 1, similarity: 0.5}
+	tests := []struct {
+		name   string
+		b      Transaction
+		wantOK bool
+		score  float64
+		keepID uint
+	}{
+		{"same line", Transaction{ID: 2, Name: "Starbucks", Amount: -500, Date: day, AccountID: &account, CreatedAt: day}, true, 1, 1},
+		{"older kept", Transaction{ID: 2, Name: "STARBUCKS", Amount: -500, Date: day, AccountID: &account, CreatedAt: older.AddDate(0, 0, -1)}, true, 1, 2},
+		{"bank line kept", Transaction{ID: 2, Name: "Starbucks", Amount: -500, Date: day, AccountID: &account, FITID: &fitA, CreatedAt: day}, true, 1, 2},
+		{"days apart", Transaction{ID: 2, Name: "Starbucks", Amount: -500, Date: day.AddDate(0, 0, 2), AccountID: &account, CreatedAt: day}, true, 0.875, 1},
+		{"amount within tolerance", Transaction{ID: 2, Name: "Starbucks", Amount: -502, Date: day, AccountID: &account, CreatedAt: day}, true, 0.9, 1},
+		{"too many days apart", Transaction{ID: 2, Name: "Starbucks", Amount: -500, Date: day.AddDate(0, 0, 4), AccountID: &account}, false, 0, 0},
+		{"amount too far", Transaction{ID: 2, Name: "Starbucks", Amount: -510, Date: day, AccountID: &account}, false, 0, 0},
+		{"other sign", Transaction{ID: 2, Name: "Starbucks", Amount: 500, Date: day, AccountID: &account}, false, 0, 0},
+		{"other account", Transaction{ID: 2, Name: "Starbucks", Amount: -500, Date: day, AccountID: &other}, false, 0, 0},
+		{"no account", Transaction{ID: 2, Name: "Starbucks", Amount: -500, Date: day}, false, 0, 0},
+		{"other name", Transaction{ID: 2, Name: "Grab", Amount: -500, Date: day, AccountID: &account}, false, 0, 0},
+	}
+	for _, tt := range tests {
+		pair, ok := duplicatePair(&base, &tt.b, o)
+		if ok != tt.wantOK {
+			t.Errorf("%s: duplicatePair ok = %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if ok && (pair.Score != tt.score || pair.KeepID != tt.keepID) {
+			t.Errorf("%s: duplicatePair = score %v keep %d, want score %v keep %d", tt.name, pair.Score, pair.KeepID, tt.score, tt.keepID)
+		}
+	}
+
+	// lines the bank told apart are never duplicates
+	a, b := base, base
+	a.FITID, b.FITID, b.ID = &fitA, &fitB, 2
+	if _, ok := duplicatePair(&a, &b, o); ok {
+		t.Errorf("duplicatePair of lines with different FITIDs ok = true, want false")
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	account := uint(1)
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	transactions := []Transaction{
+		{ID: 1, Name: "Coffee Shop", Amount: -450, Date: day, AccountID: &account},
+		{ID: 2, Name: "Starbucks", Amount: -500, Date: day, AccountID: &account},
+		{ID: 3, Name: "POS 1234 STARBUCKS JKT", Amount: -500, Date: day.AddDate(0, 0, 1), AccountID: &account},
+		{ID: 4, Name: "Starbucks", Amount: -500, Date: day.AddDate(0, 0, 10), AccountID: &account},
+	}
+	pairs := findDuplicates(transactions, duplicateOptions{days: 3, tolerance: 1, similarity: 0.5})
+	if len(pairs) != 1 || pairs[0].A.ID != 2 || pairs[0].B.ID != 3 {
+		t.Fatalf("findDuplicates = %+v, want the pair of 2 and 3", pairs)
+	}
+}
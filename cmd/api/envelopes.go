@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Envelope budgeting is zero-based: money coming in goes to a ready to
+// assign pool, opened with the account balances at the start month, and is
+// assigned from there to category envelopes month by month. Spending comes
+// out of the envelope of its category; what is left carries into the next
+// month. An overspent envelope has to be covered by moving money from
+// another one; overspending still uncovered at the end of the month is taken
+// from the next month's pool.
+
+const budgetingEnvelope = "envelope"
+
+// EnvelopeAllocation is the money assigned to a category envelope for one
+// month. Moving money out of an envelope lowers it, so it can be negative.
+type EnvelopeAllocation struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CategoryID uint      `gorm:"not null;uniqueIndex:idx_envelope_allocations_unique" json:"category_id"`
+	Month      time.Time `gorm:"type:date;not null;uniqueIndex:idx_envelope_allocations_unique" json:"month"`
+	Amount     Money     `gorm:"type:decimal(12,2);not null" json:"amount"`
+	UserID     uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Envelope is the state of one category envelope in a month. Target is the
+// amount of the category's monthly budget, if it has one.
+type Envelope struct {
+	CategoryID uint   `json:"category_id"`
+	Name       string `json:"name"`
+	Carried    Money  `json:"carried"`
+	Assigned   Money  `json:"assigned"`
+	Activity   Money  `json:"activity"`
+	Available  Money  `json:"available"`
+	Target     *Money `json:"target"`
+	Overspent  bool   `json:"overspent"`
+}
+
+// EnvelopeMonth sums up a month of envelope budgeting. Overspent is the
+// overspending not covered yet; whatever of it is left at the end of the
+// month becomes next month's OverspentLastMonth.
+type EnvelopeMonth struct {
+	Month              time.Time  `json:"month"`
+	Opening            Money      `json:"opening"`
+	Income             Money      `json:"income"`
+	Uncategorized      Money      `json:"uncategorized"`
+	OverspentLastMonth Money      `json:"overspent_last_month"`
+	Assigned           Money      `json:"assigned"`
+	ReadyToAssign      Money      `json:"ready_to_assign"`
+	Overspent          Money      `json:"overspent"`
+	Envelopes          []Envelope `json:"envelopes"`
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func monthIndex(start, month time.Time) int {
+	return (month.Year()-start.Year())*12 + int(month.Month()) - int(start.Month())
+}
+
+// envelopeStart is the first month of envelope budgeting for the user, or
+// false when the user budgets the standard way.
+func (h *Handler) envelopeStart(userID uint) (time.Time, bool) {
+	var user User
+	if err := h.DB.Select("envelope_start").First(&user, userID).Error; err != nil || user.EnvelopeStart == nil {
+		return time.Time{}, false
+	}
+	return monthStart(*user.EnvelopeStart), true
+}
+
+// envelopeMonths computes every month from start to until. Amounts are
+// converted into the base currency of cv.
+func (h *Handler) envelopeMonths(cv *currencyConverter, userID uint, start, until time.Time) ([]EnvelopeMonth, error) {
+	count := monthIndex(start, until) + 1
+	if count < 1 {
+		return nil, nil
+	}
+	end := start.AddDate(0, count, 0)
+	var categories []Category
+	if err := h.DB.Where("user_id = ?", userID).Order("name, id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	known := map[uint]bool{}
+	for _, cat := range categories {
+		known[cat.ID] = true
+	}
+
+	// the pool opens with what the accounts held when the start month began
+	var accounts []Account
+	if err := h.DB.Where("user_id = ?", userID).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	var opening Money
+	for _, acc := range accounts {
+		amount, err := cv.Convert(acc.Amount, acc.Currency)
+		if err != nil {
+			return nil, err
+		}
+		opening += amount
+	}
+	var since []currencyTotal
+	err := h.DB.Model(&Transaction{}).Where("user_id = ? AND account_id IS NOT NULL AND date >= ?", userID, start).
+		Select("currency, COALESCE(SUM(amount), 0) AS total").Group("currency").Scan(&since).Error
+	if err != nil {
+		return nil, err
+	}
+	net, _, err := cv.Sum(since)
+	if err != nil {
+		return nil, err
+	}
+	opening -= net
+
+	var rows []struct {
+		Month      time.Time
+		CategoryID *uint
+		Currency   string
+		Inflow     Money
+		Outflow    Money
+	}
+	err = h.categoryLines(userID).Where("t.date >= ? AND t.date < ?", start, end).
+		Select("date_trunc('month', t.date)::date AS month, " + lineCategory + " AS category_id, t.currency AS currency, " +
+			"COALESCE(SUM(CASE WHEN " + lineAmount + " > 0 THEN " + lineAmount + " ELSE 0 END), 0) AS inflow, " +
+			"COALESCE(SUM(CASE WHEN " + lineAmount + " < 0 THEN " + lineAmount + " ELSE 0 END), 0) AS outflow").
+		Group("1, 2, 3").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	months := make([]EnvelopeMonth, count)
+	activity := make([]map[uint]Money, count)
+	assigned := make([]map[uint]Money, count)
+	for i := range months {
+		months[i].Month = start.AddDate(0, i, 0)
+		activity[i], assigned[i] = map[uint]Money{}, map[uint]Money{}
+	}
+	for _, r := range rows {
+		i := monthIndex(start, monthStart(r.Month))
+		inflow, err := cv.Convert(r.Inflow, r.Currency)
+		if err != nil {
+			return nil, err
+		}
+		outflow, err := cv.Convert(r.Outflow, r.Currency)
+		if err != nil {
+			return nil, err
+		}
+		months[i].Income += inflow
+		if r.CategoryID != nil && known[*r.CategoryID] {
+			activity[i][*r.CategoryID] += outflow
+		} else {
+			months[i].Uncategorized += outflow
+		}
+	}
+
+	var allocations []EnvelopeAllocation
+	if err := h.DB.Where("user_id = ? AND month >= ? AND month < ?", userID, start, end).Find(&allocations).Error; err != nil {
+		return nil, err
+	}
+	for _, a := range allocations {
+		assigned[monthIndex(start, monthStart(a.Month))][a.CategoryID] += a.Amount
+	}
+	var budgets []Budget
+	h.DB.Where("user_id = ? AND criteria = ?", userID, budgetMonthly).Order("id").Find(&budgets)
+	targets := map[uint]*Money{}
+	for i := range budgets {
+		// only a budget of exactly one category sets its envelope's target
+		b := &budgets[i]
+		if len(b.CategoryIDs) == 1 && len(b.AccountIDs) == 0 && targets[b.CategoryIDs[0]] == nil {
+			targets[b.CategoryIDs[0]] = &b.Amount
+		}
+	}
+
+	fillEnvelopes(months, categories, opening, activity, assigned, targets)
+	return months, nil
+}
+
+// fillEnvelopes works out the envelopes and the pool of months, whose Income
+// and Uncategorized are set, from the opening balance of the first month and
+// the spending and assignments of each month by category. What is left in an
+// envelope carries into the next month, overspending is taken from the next
+// month's pool.
+func fillEnvelopes(months []EnvelopeMonth, categories []Category, opening Money, activity, assigned []map[uint]Money, targets map[uint]*Money) {
+	carried := map[uint]Money{}
+	var pool, overspent Money
+	for i := range months {
+		m := &months[i]
+		if i == 0 {
+			m.Opening = opening
+		}
+		m.OverspentLastMonth = overspent
+		pool += m.Opening + m.Income + m.Uncategorized - overspent
+		m.Envelopes = make([]Envelope, 0, len(categories))
+		for _, cat := range categories {
+			e := Envelope{
+				CategoryID: cat.ID,
+				Name:       cat.Name,
+				Carried:    carried[cat.ID],
+				Assigned:   assigned[i][cat.ID],
+				Activity:   activity[i][cat.ID],
+				Target:     targets[cat.ID],
+			}
+			e.Available = e.Carried + e.Assigned + e.Activity
+			m.Assigned += e.Assigned
+			carried[cat.ID] = e.Available
+			if e.Available < 0 {
+				e.Overspent = true
+				m.Overspent -= e.Available
+				carried[cat.ID] = 0
+			}
+			m.Envelopes = append(m.Envelopes, e)
+		}
+		pool -= m.Assigned
+		m.ReadyToAssign = pool
+		overspent = m.Overspent
+	}
+}
+
+// envelopeMonthParam parses a YYYY-MM month, the current month when empty.
+func envelopeMonthParam(v string) (time.Time, error) {
+	if v == "" {
+		return monthStart(time.Now()), nil
+	}
+	month, err := time.Parse("2006-01", v)
+	if err != nil {
+		return time.Time{}, errors.New("month must be YYYY-MM")
+	}
+	return month, nil
+}
+
+var errEnvelopesDisabled = errors.New("envelope budgeting is not enabled, set budgeting_mode to envelope in the settings")
+
+// loadEnvelopes computes the months from the start of envelope budgeting up
+// to month, or up to the current month when that is later, since assigning
+// money to a month also takes it from the pool of the months after it. It
+// answers the request itself when it fails.
+func (h *Handler) loadEnvelopes(c *gin.Context, cv *currencyConverter, month time.Time) ([]EnvelopeMonth, int, bool) {
+	userID := c.GetUint("user_id")
+	start, ok := h.envelopeStart(userID)
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": errEnvelopesDisabled.Error()})
+		return nil, 0, false
+	}
+	if month.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "envelope budgeting starts in " + start.Format("2006-01")})
+		return nil, 0, false
+	}
+	until := monthStart(time.Now())
+	if month.After(until) {
+		until = month
+	}
+	months, err := h.envelopeMonths(cv, userID, start, until)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
+	return months, monthIndex(start, month), true
+}
+
+// GetEnvelopes shows the envelopes of a month (?month=YYYY-MM, default the
+// current one) with the money ready to assign.
+func (h *Handler) GetEnvelopes(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	month, err := envelopeMonthParam(c.Query("month"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cv := h.newConverter(userID, time.Now())
+	months, i, ok := h.loadEnvelopes(c, cv, month)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"month":    months[i],
+		"currency": cv.base,
+		"rates":    cv.Applied(),
+	})
+}
+
+// errAnswered ends a database transaction whose request has already been
+// answered.
+var errAnswered = errors.New("request answered")
+
+// lockEnvelopes locks the row of the user in tx, so that the assignments and
+// moves of a user run one at a time and each checks what the one before it
+// left, and then computes the months from tx like loadEnvelopes.
+func (h *Handler) lockEnvelopes(c *gin.Context, tx *gorm.DB, cv *currencyConverter, month time.Time) ([]EnvelopeMonth, int, bool) {
+	userID := c.GetUint("user_id")
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, 0, false
+	}
+	return (&Handler{DB: tx}).loadEnvelopes(c, cv, month)
+}
+
+// readyFrom is the money that can still be assigned in month i: the least
+// ready to assign of it and every later month.
+func readyFrom(months []EnvelopeMonth, i int) Money {
+	ready := months[i].ReadyToAssign
+	for _, m := range months[i+1:] {
+		if m.ReadyToAssign < ready {
+			ready = m.ReadyToAssign
+		}
+	}
+	return ready
+}
+
+// addAllocation adds amount to the allocation of a category in a month.
+func addAllocation(tx *gorm.DB, userID, categoryID uint, month time.Time, amount Money) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "category_id"}, {Name: "month"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"amount":     gorm.Expr("envelope_allocations.amount + EXCLUDED.amount"),
+			"updated_at": time.Now(),
+		}),
+	}).Create(&EnvelopeAllocation{CategoryID: categoryID, Month: month, Amount: amount, UserID: userID}).Error
+}
+
+// findEnvelope returns the index of the envelope of a category in a month.
+func findEnvelope(m *EnvelopeMonth, categoryID uint) (int, bool) {
+	for i, e := range m.Envelopes {
+		if e.CategoryID == categoryID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// AssignEnvelope sets the money assigned to a category envelope for a month.
+// Raising it takes the difference from the money ready to assign, which
+// cannot go below zero.
+func (h *Handler) AssignEnvelope(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		Amount *Money `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	month, err := envelopeMonthParam(c.Param("month"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	categoryID, err := strconv.ParseUint(c.Param("category_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+	cv := h.newConverter(userID, time.Now())
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		months, i, ok := h.lockEnvelopes(c, tx, cv, month)
+		if !ok {
+			return errAnswered
+		}
+		e, found := findEnvelope(&months[i], uint(categoryID))
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+			return errAnswered
+		}
+		delta := *req.Amount - months[i].Envelopes[e].Assigned
+		if ready := readyFrom(months, i); delta > 0 && delta > ready {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("only %s is ready to assign", ready)})
+			return errAnswered
+		}
+		if delta == 0 {
+			return nil
+		}
+		return addAllocation(tx, userID, uint(categoryID), month, delta)
+	})
+	if errors.Is(err, errAnswered) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	months, i, ok := h.loadEnvelopes(c, cv, month)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"month": months[i], "currency": cv.base})
+}
+
+// MoveEnvelopeMoney moves money between two envelopes of a month, or between
+// an envelope and the money ready to assign when either category is left
+// out. This is how overspending is covered. The source cannot give more
+// than it has available.
+func (h *Handler) MoveEnvelopeMoney(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		Month          string `json:"month"`
+		FromCategoryID *uint  `json:"from_category_id"`
+		ToCategoryID   *uint  `json:"to_category_id"`
+		Amount         Money  `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.FromCategoryID == nil && req.ToCategoryID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_category_id or to_category_id is required"})
+		return
+	}
+	if req.FromCategoryID != nil && req.ToCategoryID != nil && *req.FromCategoryID == *req.ToCategoryID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot move money to the same envelope"})
+		return
+	}
+	month, err := envelopeMonthParam(req.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cv := h.newConverter(userID, time.Now())
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		months, i, ok := h.lockEnvelopes(c, tx, cv, month)
+		if !ok {
+			return errAnswered
+		}
+		for _, id := range []*uint{req.FromCategoryID, req.ToCategoryID} {
+			if id == nil {
+				continue
+			}
+			if _, found := findEnvelope(&months[i], *id); !found {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+				return errAnswered
+			}
+		}
+		available := readyFrom(months, i)
+		if req.FromCategoryID != nil {
+			e, _ := findEnvelope(&months[i], *req.FromCategoryID)
+			available = months[i].Envelopes[e].Available
+		}
+		if req.Amount > available {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("only %s is available to move", available)})
+			return errAnswered
+		}
+		if req.FromCategoryID != nil {
+			if err := addAllocation(tx, userID, *req.FromCategoryID, month, -req.Amount); err != nil {
+				return err
+			}
+		}
+		if req.ToCategoryID != nil {
+			return addAllocation(tx, userID, *req.ToCategoryID, month, req.Amount)
+		}
+		return nil
+	})
+	if errors.Is(err, errAnswered) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	months, i, ok := h.loadEnvelopes(c, cv, month)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"month": months[i], "currency": cv.base})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFillEnvelopes(t *testing.T) {
+	categories := []Category{{ID: 1, Name: "Groceries"}, {ID: 2, Name: "Rent"}}
+	rent := Money(60000)
+	months := []EnvelopeMonth{
+		{Month: mustDate(t, "2024-01-01"), Income: 100000, Uncategorized: -5000},
+		{Month: mustDate(t, "2024-02-01"), Income: 50000},
+		{Month: mustDate(t, "2024-03-01")},
+	}
+	assigned := []map[uint]Money{{1: 30000, 2: 60000}, {1: 20000}, {}}
+	activity := []map[uint]Money{{1: -25000, 2: -70000}, {1: -30000}, {1: -1000}}
+	fillEnvelopes(months, categories, 20000, activity, assigned, map[uint]*Money{2: &rent})
+
+	tests := []struct {
+		opening, overspentLastMonth, assigned, ready, overspent Money
+		// available per envelope, negative when overspent
+		available [2]Money
+	}{
+		// the pool opens with 200.00 + 1000.00 income - 50.00 uncategorized
+		// spending; rent is overspent by 100.00
+		{20000, 0, 90000, 25000, 10000, [2]Money{5000, -10000}},
+		// last month's overspending comes out of the pool, groceries carry
+		// 50.00 over and are overspent by 50.00, rent starts again at zero
+		{0, 10000, 20000, 45000, 5000, [2]Money{-5000, 0}},
+		{0, 5000, 0, 40000, 1000, [2]Money{-1000, 0}},
+	}
+	for i, tt := range tests {
+		m := months[i]
+		if m.Opening != tt.opening || m.OverspentLastMonth != tt.overspentLastMonth || m.Assigned != tt.assigned ||
+			m.ReadyToAssign != tt.ready || m.Overspent != tt.overspent {
+			t.Errorf("month %d = opening %s, overspent last month %s, assigned %s, ready %s, overspent %s; want %s, %s, %s, %s, %s",
+				i, m.Opening, m.OverspentLastMonth, m.Assigned, m.ReadyToAssign, m.Overspent,
+				tt.opening, tt.overspentLastMonth, tt.assigned, tt.ready, tt.overspent)
+		}
+		for j, e := range m.Envelopes {
+			if e.Available != tt.available[j] || e.Overspent != (tt.available[j] < 0) {
+				t.Errorf("month %d %s = available %s, overspent %v; want %s", i, e.Name, e.Available, e.Overspent, tt.available[j])
+			}
+		}
+	}
+	if e := months[1].Envelopes[0]; e.Carried != 5000 || e.Target != nil {
+		t.Errorf("groceries in February = carried %s, target %v; want 50.00 and none", e.Carried, e.Target)
+	}
+	if e := months[0].Envelopes[1]; e.Target == nil || *e.Target != rent {
+		t.Errorf("rent target = %v, want 600.00", e.Target)
+	}
+
+	// money assigned in January also leaves the later months' pools
+	for i, want := range []Money{25000, 40000, 40000} {
+		if got := readyFrom(months, i); got != want {
+			t.Errorf("readyFrom(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestMonthIndex(t *testing.T) {
+	start := mustDate(t, "2023-11-01")
+	tests := []struct {
+		month time.Time
+		want  int
+	}{
+		{start, 0},
+		{mustDate(t, "2023-12-01"), 1},
+		{mustDate(t, "2024-03-01"), 4},
+		{mustDate(t, "2023-10-01"), -1},
+	}
+	for _, tt := range tests {
+		if got := monthIndex(start, tt.month); got != tt.want {
+			t.Errorf("monthIndex(%s) = %d, want %d", tt.month.Format("2006-01"), got, tt.want)
+		}
+	}
+}
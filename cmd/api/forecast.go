@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultForecastDays = 90
+	maxForecastDays     = 730
+)
+
+// ForecastItem is one scheduled occurrence expected on a forecast day.
+type ForecastItem struct {
+	ScheduledTransactionID uint      `json:"scheduled_transaction_id"`
+	Name                   string    `json:"name"`
+	Amount                 Money     `json:"amount"`
+	DueAt                  time.Time `json:"due_at"`
+}
+
+// ForecastDay is the projected end-of-day balance of an account.
+type ForecastDay struct {
+	Date           time.Time      `json:"date"`
+	Balance        Money          `json:"balance"`
+	Change         Money          `json:"change"`
+	Items          []ForecastItem `json:"items,omitempty"`
+	BelowZero      bool           `json:"below_zero"`
+	BelowThreshold bool           `json:"below_threshold"`
+	OverLimit      bool           `json:"over_limit"`
+}
+
+// ForecastAlert marks the day an account's balance drops below zero or the
+// threshold, or a credit card's debt goes over its limit, ending a stretch
+// within it.
+type ForecastAlert struct {
+	Date    time.Time `json:"date"`
+	Balance Money     `json:"balance"`
+	Reason  string    `json:"reason"`
+}
+
+type AccountForecast struct {
+	AccountID       uint            `json:"account_id"`
+	BankName        string          `json:"bank_name"`
+	Currency        string          `json:"currency"`
+	StartingBalance Money           `json:"starting_balance"`
+	Threshold       *Money          `json:"threshold,omitempty"`
+	LowestBalance   Money           `json:"lowest_balance"`
+	LowestDate      time.Time       `json:"lowest_date"`
+	Days            []ForecastDay   `json:"days"`
+	Alerts          []ForecastAlert `json:"alerts"`
+}
+
+// GetForecast projects the daily balance of every account over the next days
+// (default 90) from its current amount and the occurrences of the scheduled
+// transactions booked to it, with skipped, moved and overridden occurrences
+// taken into account. Occurrences that are already due but not posted yet
+// count on the first day. Days ending below zero, or below the optional
+// threshold, are flagged, and each drop below either is reported as an
+// alert. The threshold is in the base currency and is converted into the
+// currency of each account. Credit cards and loans are below zero by nature,
+// so they are flagged instead when the debt goes over the credit limit.
+func (h *Handler) GetForecast(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	days := defaultForecastDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxForecastDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxForecastDays)})
+			return
+		}
+		days = n
+	}
+	var threshold *Money
+	if v := c.Query("threshold"); v != "" {
+		t, err := ParseMoney(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold: " + err.Error()})
+			return
+		}
+		threshold = &t
+	}
+	var accounts []Account
+	if err := h.DB.Where("user_id = ?", userID).Order("id").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	from := dateOnly(time.Now())
+	cv := h.newConverter(userID, from)
+	thresholds := make([]*Money, len(accounts))
+	for i, acc := range accounts {
+		if threshold == nil {
+			continue
+		}
+		t, err := cv.FromBase(*threshold, acc.Currency)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		thresholds[i] = &t
+	}
+	var scheduled []ScheduledTransaction
+	if err := h.DB.Where("user_id = ? AND account_id IS NOT NULL", userID).Find(&scheduled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ids := make([]uint, len(scheduled))
+	for i, st := range scheduled {
+		ids[i] = st.ID
+	}
+	exceptions, err := pendingExceptions(h.DB, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	to := from.AddDate(0, 0, days-1)
+	items, err := forecastItems(scheduled, exceptions, from, to)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	forecasts := make([]AccountForecast, 0, len(accounts))
+	for i, acc := range accounts {
+		forecasts = append(forecasts, projectAccount(acc, items[acc.ID], from, days, thresholds[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":      from,
+		"to":        to,
+		"threshold": threshold,
+		"currency":  cv.base,
+		"rates":     cv.Applied(),
+		"accounts":  forecasts,
+	})
+}
+
+// forecastItems expands the scheduled transactions into the occurrences due
+// up to to, with their pending exceptions applied, grouped by account and
+// day. Earlier occurrences land on from.
+func forecastItems(scheduled []ScheduledTransaction, exceptions map[uint][]ScheduledException, from, to time.Time) (map[uint]map[time.Time][]ForecastItem, error) {
+	end := to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	items := map[uint]map[time.Time][]ForecastItem{}
+	for i := range scheduled {
+		st := &scheduled[i]
+		rec, err := st.recurrence()
+		if err != nil {
+			return nil, fmt.Errorf("scheduled transaction %d: %w", st.ID, err)
+		}
+		var ruleDates []time.Time
+		if !st.Completed {
+			ruleDates = rec.Between(st.RepeatAt, end, maxForecastDays*31)
+		}
+		for _, in := range st.instances(rec, ruleDates, exceptions[st.ID], end) {
+			if in.Skipped {
+				continue
+			}
+			day := dateOnly(in.DueAt)
+			if day.Before(from) {
+				day = from
+			}
+			if items[*st.AccountID] == nil {
+				items[*st.AccountID] = map[time.Time][]ForecastItem{}
+			}
+			items[*st.AccountID][day] = append(items[*st.AccountID][day], ForecastItem{
+				ScheduledTransactionID: st.ID,
+				Name:                   st.Name,
+				Amount:                 in.Amount,
+				DueAt:                  in.DueAt,
+			})
+		}
+	}
+	return items, nil
+}
+
+// projectAccount runs the balance of acc forward over days from from.
+// threshold is in the account's currency and ignored for liabilities.
+func projectAccount(acc Account, items map[time.Time][]ForecastItem, from time.Time, days int, threshold *Money) AccountForecast {
+	liability := isLiability(acc.Type)
+	if liability {
+		threshold = nil
+	}
+	f := AccountForecast{
+		AccountID:       acc.ID,
+		BankName:        acc.BankName,
+		Currency:        acc.Currency,
+		StartingBalance: acc.Amount,
+		Threshold:       threshold,
+		LowestBalance:   acc.Amount,
+		LowestDate:      from,
+		Days:            make([]ForecastDay, 0, days),
+		Alerts:          []ForecastAlert{},
+	}
+	balance := acc.Amount
+	wasBelowZero, wasBelowThreshold, wasOverLimit := false, false, false
+	for i := 0; i < days; i++ {
+		date := from.AddDate(0, 0, i)
+		day := ForecastDay{Date: date, Items: items[date]}
+		for _, item := range day.Items {
+			day.Change += item.Amount
+		}
+		balance += day.Change
+		day.Balance = balance
+		day.BelowZero = !liability && balance < 0
+		day.BelowThreshold = threshold != nil && balance < *threshold
+		day.OverLimit = liability && acc.CreditLimit != nil && -balance > *acc.CreditLimit
+		if day.BelowZero && !wasBelowZero {
+			f.Alerts = append(f.Alerts, ForecastAlert{Date: date, Balance: balance, Reason: "below_zero"})
+		}
+		if day.BelowThreshold && !wasBelowThreshold {
+			f.Alerts = append(f.Alerts, ForecastAlert{Date: date, Balance: balance, Reason: "below_threshold"})
+		}
+		if day.OverLimit && !wasOverLimit {
+			f.Alerts = append(f.Alerts, ForecastAlert{Date: date, Balance: balance, Reason: "over_limit"})
+		}
+		wasBelowZero, wasBelowThreshold, wasOverLimit = day.BelowZero, day.BelowThreshold, day.OverLimit
+		if balance < f.LowestBalance {
+			f.LowestBalance, f.LowestDate = balance, date
+		}
+		f.Days = append(f.Days, day)
+	}
+	return f
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestForecastItems(t *testing.T) {
+	account, other := uint(1), uint(2)
+	from := mustDate(t, "2024-03-10")
+	at := func(s string) time.Time { return mustDate(t, s).Add(8 * time.Hour) }
+	moveTo, amount := mustDate(t, "2024-03-22"), Money(-7500)
+	scheduled := []ScheduledTransaction{
+		// weekly from the 6th: the 6th is overdue, the 13th is skipped, the
+		// 20th is moved to the 22nd and the 27th costs more
+		{ID: 1, Name: "Groceries", Amount: -5000, Repetition: "weekly", StartsAt: at("2024-03-06"), RepeatAt: at("2024-03-06"), AccountID: &account},
+		{ID: 2, Name: "Salary", Amount: 100000, Repetition: "monthly", StartsAt: at("2024-03-25"), RepeatAt: at("2024-03-25"), AccountID: &other},
+		{ID: 3, Name: "Done", Amount: -100, Repetition: "weekly", StartsAt: at("2024-03-01"), RepeatAt: at("2024-03-01"), Completed: true, AccountID: &account},
+	}
+	exceptions := map[uint][]ScheduledException{1: {
+		{ScheduledTransactionID: 1, OccursOn: mustDate(t, "2024-03-13"), Skip: true},
+		{ScheduledTransactionID: 1, OccursOn: mustDate(t, "2024-03-20"), MoveTo: &moveTo},
+		{ScheduledTransactionID: 1, OccursOn: mustDate(t, "2024-03-27"), Amount: &amount},
+	}}
+	items, err := forecastItems(scheduled, exceptions, from, mustDate(t, "2024-03-31"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint]map[time.Time][]ForecastItem{
+		account: {
+			from:                      {{1, "Groceries", -5000, at("2024-03-06")}},
+			mustDate(t, "2024-03-22"): {{1, "Groceries", -5000, at("2024-03-22")}},
+			mustDate(t, "2024-03-27"): {{1, "Groceries", -7500, at("2024-03-27")}},
+		},
+		other: {
+			mustDate(t, "2024-03-25"): {{2, "Salary", 100000, at("2024-03-25")}},
+		},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("forecastItems =\n%v\nwant\n%v", items, want)
+	}
+
+	bad := []ScheduledTransaction{{ID: 4, RRule: "FREQ=SOMETIMES", StartsAt: from, RepeatAt: from, AccountID: &account}}
+	if _, err := forecastItems(bad, nil, from, from); err == nil {
+		t.Errorf("forecastItems with an invalid rule error = nil, want an error")
+	}
+}
+
+func TestProjectAccount(t *testing.T) {
+	from := mustDate(t, "2024-03-01")
+	day := func(n int) time.Time { return from.AddDate(0, 0, n) }
+	items := map[time.Time][]ForecastItem{
+		day(1): {{Amount: -3000}, {Amount: -500}},
+		day(2): {{Amount: 10000}},
+		day(4): {{Amount: -9000}},
+	}
+	threshold := Money(1000)
+	tests := []struct {
+		name      string
+		threshold *Money
+		balances  []Money
+		lowest    Money
+		lowestDay int
+		alerts    []ForecastAlert
+	}{
+		{"no threshold", nil, []Money{2000, -1500, 8500, 8500, -500}, -1500, 1, []ForecastAlert{
+			{day(1), -1500, "below_zero"},
+			{day(4), -500, "below_zero"},
+		}},
+		{"threshold", &threshold, []Money{2000, -1500, 8500, 8500, -500}, -1500, 1, []ForecastAlert{
+			{day(1), -1500, "below_zero"},
+			{day(1), -1500, "below_threshold"},
+			{day(4), -500, "below_zero"},
+			{day(4), -500, "below_threshold"},
+		}},
+	}
+	for _, tt := range tests {
+		f := projectAccount(Account{ID: 1, Amount: 2000, Currency: "USD"}, items, from, 5, tt.threshold)
+		var balances []Money
+		for _, d := range f.Days {
+			balances = append(balances, d.Balance)
+		}
+		if !reflect.DeepEqual(balances, tt.balances) {
+			t.Errorf("%s: balances = %v, want %v", tt.name, balances, tt.balances)
+		}
+		if f.Days[1].Change != -3500 || len(f.Days[1].Items) != 2 {
+			t.Errorf("%s: day 1 = %+v, want a change of -35.00 from two items", tt.name, f.Days[1])
+		}
+		if f.LowestBalance != tt.lowest || !f.LowestDate.Equal(day(tt.lowestDay)) {
+			t.Errorf("%s: lowest = %s on %s, want %s on %s", tt.name, f.LowestBalance, f.LowestDate, tt.lowest, day(tt.lowestDay))
+		}
+		if !reflect.DeepEqual(f.Alerts, tt.alerts) {
+			t.Errorf("%s: alerts = %v, want %v", tt.name, f.Alerts, tt.alerts)
+		}
+	}
+}
+
+func TestConverterFromBase(t *testing.T) {
+	cv := &currencyConverter{
+		base:  "IDR",
+		rates: map[string]*big.Rat{"USD": big.NewRat(15000, 1)},
+		used:  map[string]AppliedRate{},
+	}
+	tests := []struct {
+		amount   Money
+		currency string
+		want     Money
+	}{
+		{10000000, "IDR", 10000000},
+		{10000000, "", 10000000},
+		{10000000, "USD", 667},
+		{-15000000, "USD", -1000},
+	}
+	for _, tt := range tests {
+		got, err := cv.FromBase(tt.amount, tt.currency)
+		if err != nil || got != tt.want {
+			t.Errorf("FromBase(%s, %q) = %s, %v; want %s", tt.amount, tt.currency, got, err, tt.want)
+		}
+	}
+}
+
+func TestProjectLiability(t *testing.T) {
+	from := mustDate(t, "2024-03-01")
+	day := func(n int) time.Time { return from.AddDate(0, 0, n) }
+	items := map[time.Time][]ForecastItem{
+		day(1): {{Amount: -30000}},
+		day(2): {{Amount: 50000}},
+		day(3): {{Amount: -50000}},
+	}
+	limit, threshold := Money(100000), Money(1000)
+	tests := []struct {
+		name    string
+		account Account
+		alerts  []ForecastAlert
+	}{
+		{"credit card within its limit", Account{Type: accountCreditCard, Amount: -50000}, []ForecastAlert{}},
+		{"credit card over its limit", Account{Type: accountCreditCard, Amount: -80000, CreditLimit: &limit}, []ForecastAlert{
+			{day(1), -110000, "over_limit"},
+			{day(3), -110000, "over_limit"},
+		}},
+		{"loan", Account{Type: accountLoan, Amount: -5000000}, []ForecastAlert{}},
+		{"checking", Account{Type: accountChecking, Amount: 20000}, []ForecastAlert{
+			{day(1), -10000, "below_zero"},
+			{day(1), -10000, "below_threshold"},
+			{day(3), -10000, "below_zero"},
+			{day(3), -10000, "below_threshold"},
+		}},
+	}
+	for _, tt := range tests {
+		f := projectAccount(tt.account, items, from, 4, &threshold)
+		if !reflect.DeepEqual(f.Alerts, tt.alerts) {
+			t.Errorf("%s: alerts = %v, want %v", tt.name, f.Alerts, tt.alerts)
+		}
+		if isLiability(tt.account.Type) && f.Threshold != nil {
+			t.Errorf("%s: threshold = %s, want none for a liability", tt.name, f.Threshold)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// ImportProfile describes how to read one bank's CSV statements. Columns are
+// given either as a header name or as a 1-based column number. A statement
+// has either a signed amount column or separate debit and credit columns.
+type ImportProfile struct {
+	ID                uint      `gorm:"primaryKey" json:"id"`
+	Name              string    `gorm:"size:255;not null" json:"name"`
+	Delimiter         string    `gorm:"size:1;not null" json:"delimiter"`
+	HasHeader         bool      `gorm:"not null" json:"has_header"`
+	SkipRows          int       `gorm:"not null" json:"skip_rows"`
+	DateColumn        string    `gorm:"size:100;not null" json:"date_column"`
+	DateFormat        string    `gorm:"size:50;not null" json:"date_format"`
+	AmountColumn      string    `gorm:"size:100" json:"amount_column"`
+	DebitColumn       string    `gorm:"size:100" json:"debit_column"`
+	CreditColumn      string    `gorm:"size:100" json:"credit_column"`
+	DescriptionColumn string    `gorm:"size:100" json:"description_column"`
+	DecimalSeparator  string    `gorm:"size:1;not null" json:"decimal_separator"`
+	Encoding          string    `gorm:"size:20;not null" json:"encoding"`
+	UserID            uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// csvEncodings lists the supported statement encodings.
+var csvEncodings = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8BOM,
+	"utf-16":       unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"windows-1252": charmap.Windows1252,
+}
+
+// normalize fills in defaults and rejects profiles that cannot be applied.
+func (p *ImportProfile) normalize() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	switch p.Delimiter {
+	case "":
+		p.Delimiter = ","
+	case `\t`, "tab":
+		p.Delimiter = "\t"
+	}
+	if utf8.RuneCountInString(p.Delimiter) != 1 || p.Delimiter == "\n" || p.Delimiter == `"` {
+		return errors.New("delimiter must be a single character")
+	}
+	if p.SkipRows < 0 {
+		return errors.New("skip_rows cannot be negative")
+	}
+	if p.DateColumn == "" || p.DateFormat == "" {
+		return errors.New("date_column and date_format are required")
+	}
+	if p.AmountColumn == "" && p.DebitColumn == "" && p.CreditColumn == "" {
+		return errors.New("amount_column or debit_column/credit_column is required")
+	}
+	if p.AmountColumn != "" && (p.DebitColumn != "" || p.CreditColumn != "") {
+		return errors.New("use either amount_column or debit_column/credit_column")
+	}
+	if p.DecimalSeparator == "" {
+		p.DecimalSeparator = "."
+	}
+	if p.DecimalSeparator != "." && p.DecimalSeparator != "," {
+		return errors.New(`decimal_separator must be "." or ","`)
+	}
+	p.Encoding = strings.ToLower(p.Encoding)
+	switch p.Encoding {
+	case "", "utf8":
+		p.Encoding = "utf-8"
+	case "latin1", "latin-1":
+		p.Encoding = "iso-8859-1"
+	case "cp1252":
+		p.Encoding = "windows-1252"
+	}
+	if _, ok := csvEncodings[p.Encoding]; !ok {
+		return fmt.Errorf("unsupported encoding %q", p.Encoding)
+	}
+	for _, col := range []string{p.DateColumn, p.AmountColumn, p.DebitColumn, p.CreditColumn, p.DescriptionColumn} {
+		if _, err := strconv.Atoi(col); col != "" && err != nil && !p.HasHeader {
+			return fmt.Errorf("column %q is referenced by name but the profile has no header", col)
+		}
+	}
+	return nil
+}
+
+// dateLayout turns a date format such as "DD/MM/YYYY" into a Go layout. A Go
+// layout is accepted as is.
+func dateLayout(format string) string {
+	if strings.Contains(format, "2006") || strings.Contains(format, "06") {
+		return format
+	}
+	return strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02").Replace(format)
+}
+
+// parseStatementAmount reads an amount written with the given decimal
+// separator. The other separator is taken as a thousands separator and
+// "(12.50)" or "12.50-" denote negative amounts.
+func parseStatementAmount(s, decimalSeparator string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+	if strings.HasSuffix(s, "-") {
+		negative = true
+		s = strings.TrimSuffix(s, "-")
+	}
+	thousands := ","
+	if decimalSeparator == "," {
+		thousands = "."
+	}
+	s = strings.NewReplacer(thousands, "", " ", "", " ", "", "'", "").Replace(s)
+	s = strings.Replace(s, decimalSeparator, ".", 1)
+	s = strings.TrimLeftFunc(s, func(r rune) bool {
+		return r != '-' && r != '+' && r != '.' && (r < '0' || r > '9')
+	})
+	m, err := ParseMoney(s)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		m = -m.Abs()
+	}
+	return m, nil
+}
+
+// csvColumns resolves the profile's column references to record indexes;
+// unused columns resolve to -1.
+type csvColumns struct {
+	date, amount, debit, credit, description int
+}
+
+func resolveColumns(p ImportProfile, header []string) (csvColumns, error) {
+	resolve := func(ref string) (int, error) {
+		if ref == "" {
+			return -1, nil
+		}
+		if n, err := strconv.Atoi(ref); err == nil {
+			if n < 1 {
+				return -1, fmt.Errorf("invalid column number %d", n)
+			}
+			return n - 1, nil
+		}
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(ref)) {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("column %q not found in header", ref)
+	}
+	var cols csvColumns
+	var err error
+	for _, c := range []struct {
+		ref string
+		dst *int
+	}{
+		{p.DateColumn, &cols.date},
+		{p.AmountColumn, &cols.amount},
+		{p.DebitColumn, &cols.debit},
+		{p.CreditColumn, &cols.credit},
+		{p.DescriptionColumn, &cols.description},
+	} {
+		if *c.dst, err = resolve(c.ref); err != nil {
+			return cols, err
+		}
+	}
+	return cols, nil
+}
+
+// parseCSVRecord turns one statement line into a transaction. It returns
+// a nil transaction with a reason for lines that should be skipped.
+func parseCSVRecord(p ImportProfile, cols csvColumns, record []string) (*Transaction, string, error) {
+	field := func(i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	empty := true
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			empty = false
+			break
+		}
+	}
+	if empty {
+		return nil, "empty row", nil
+	}
+	date, err := time.Parse(dateLayout(p.DateFormat), field(cols.date))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid date %q", field(cols.date))
+	}
+	var amount Money
+	if cols.amount >= 0 {
+		if amount, err = parseStatementAmount(field(cols.amount), p.DecimalSeparator); err != nil {
+			return nil, "", fmt.Errorf("invalid amount %q: %v", field(cols.amount), err)
+		}
+	} else {
+		if v := field(cols.credit); v != "" {
+			credit, err := parseStatementAmount(v, p.DecimalSeparator)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid credit %q: %v", v, err)
+			}
+			amount += credit.Abs()
+		}
+		if v := field(cols.debit); v != "" {
+			debit, err := parseStatementAmount(v, p.DecimalSeparator)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid debit %q: %v", v, err)
+			}
+			amount -= debit.Abs()
+		}
+	}
+	if amount == 0 {
+		return nil, "zero amount", nil
+	}
+	name := field(cols.description)
+	if name == "" {
+		name = "Imported transaction"
+	}
+	if utf8.RuneCountInString(name) > 255 {
+		name = string([]rune(name)[:255])
+	}
+	return &Transaction{Name: name, Amount: amount, Date: date}, "", nil
+}
+
+func (h *Handler) GetImportProfiles(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var profiles []ImportProfile
+	h.DB.Where("user_id = ?", userID).Order("name").Find(&profiles)
+	c.JSON(http.StatusOK, profiles)
+}
+
+func (h *Handler) CreateImportProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var profile ImportProfile
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	profile.ID = 0
+	profile.UserID = userID
+	if err := profile.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, profile)
+}
+
+func (h *Handler) UpdateImportProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var profile ImportProfile
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import profile not found"})
+		return
+	}
+	id := profile.ID
+	if err := c.ShouldBindJSON(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	profile.ID = id
+	profile.UserID = userID
+	if err := profile.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+func (h *Handler) DeleteImportProfile(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	if result := h.DB.Where("user_id = ?", userID).Delete(&ImportProfile{}, c.Param("id")); result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import profile not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ImportCSV books a CSV statement into an account. It expects a multipart
+// form with the file, a profile_id and an account_id, and answers with a
+// report for every line after the header.
+func (h *Handler) ImportCSV(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var profile ImportProfile
+	if err := h.DB.Where("id = ? AND user_id = ?", c.PostForm("profile_id"), userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "import profile not found"})
+		return
+	}
+	var account Account
+	if err := h.DB.Where("id = ? AND user_id = ?", c.PostForm("account_id"), userID).First(&account).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account not found"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing CSV file"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	raw, err := io.ReadAll(transform.NewReader(f, csvEncodings[profile.Encoding].NewDecoder()))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cannot decode file as %s: %v", profile.Encoding, err)})
+		return
+	}
+	reader := csv.NewReader(bytes.NewReader(raw))
+	reader.Comma, _ = utf8.DecodeRuneInString(profile.Delimiter)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		records = append(records, record)
+	}
+	first := profile.SkipRows
+	var header []string
+	if profile.HasHeader {
+		if first >= len(records) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file has no header row"})
+			return
+		}
+		header = records[first]
+		first++
+	}
+	cols, err := resolveColumns(profile, header)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report := &ImportReport{Rows: []ImportRowResult{}}
+	var candidates []importCandidate
+	for i := first; i < len(records); i++ {
+		row := i + 1
+		t, skip, err := parseCSVRecord(profile, cols, records[i])
+		switch {
+		case err != nil:
+			report.add(row, importFailed, err.Error(), 0)
+		case t == nil:
+			report.add(row, importSkipped, skip, 0)
+		default:
+			candidates = append(candidates, importCandidate{Row: row, Transaction: *t})
+		}
+	}
+	if err := h.bookImport(userID, account, candidates, report); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportProfileNormalize(t *testing.T) {
+	valid := func() ImportProfile {
+		return ImportProfile{Name: " Bank ", HasHeader: true, DateColumn: "Date", DateFormat: "DD/MM/YYYY", AmountColumn: "Amount"}
+	}
+	p := valid()
+	if err := p.normalize(); err != nil {
+		t.Fatalf("normalize error = %v", err)
+	}
+	if p.Name != "Bank" || p.Delimiter != "," || p.DecimalSeparator != "." || p.Encoding != "utf-8" {
+		t.Errorf("normalize defaults = %q %q %q %q, want Bank , . utf-8", p.Name, p.Delimiter, p.DecimalSeparator, p.Encoding)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(*ImportProfile)
+		wantErr  bool
+		delim    string
+		encoding string
+	}{
+		{"tab", func(p *ImportProfile) { p.Delimiter = "tab" }, false, "\t", "utf-8"},
+		{"escaped tab", func(p *ImportProfile) { p.Delimiter = `\t` }, false, "\t", "utf-8"},
+		{"semicolon", func(p *ImportProfile) { p.Delimiter = ";" }, false, ";", "utf-8"},
+		{"latin1", func(p *ImportProfile) { p.Encoding = "Latin1" }, false, ",", "iso-8859-1"},
+		{"cp1252", func(p *ImportProfile) { p.Encoding = "CP1252" }, false, ",", "windows-1252"},
+		{"debit and credit", func(p *ImportProfile) { p.AmountColumn, p.DebitColumn, p.CreditColumn = "", "Out", "In" }, false, ",", "utf-8"},
+		{"columns by number", func(p *ImportProfile) { p.HasHeader, p.DateColumn, p.AmountColumn = false, "1", "3" }, false, ",", "utf-8"},
+		{"no name", func(p *ImportProfile) { p.Name = " " }, true, "", ""},
+		{"long delimiter", func(p *ImportProfile) { p.Delimiter = ";;" }, true, "", ""},
+		{"quote delimiter", func(p *ImportProfile) { p.Delimiter = `"` }, true, "", ""},
+		{"negative skip", func(p *ImportProfile) { p.SkipRows = -1 }, true, "", ""},
+		{"no date format", func(p *ImportProfile) { p.DateFormat = "" }, true, "", ""},
+		{"no amount", func(p *ImportProfile) { p.AmountColumn = "" }, true, "", ""},
+		{"amount and debit", func(p *ImportProfile) { p.DebitColumn = "Out" }, true, "", ""},
+		{"decimal separator", func(p *ImportProfile) { p.DecimalSeparator = ";" }, true, "", ""},
+		{"encoding", func(p *ImportProfile) { p.Encoding = "ebcdic" }, true, "", ""},
+		{"names without a header", func(p *ImportProfile) { p.HasHeader = false }, true, "", ""},
+	}
+	for _, tt := range tests {
+		p := valid()
+		tt.change(&p)
+		err := p.normalize()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: normalize error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (p.Delimiter != tt.delim || p.Encoding != tt.encoding) {
+			t.Errorf("%s: delimiter %q, encoding %q; want %q, %q", tt.name, p.Delimiter, p.Encoding, tt.delim, tt.encoding)
+		}
+	}
+}
+
+func TestDateLayout(t *testing.T) {
+	tests := []struct {
+		format, value string
+		want          time.Time
+	}{
+		{"DD/MM/YYYY", "05/03/2024", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"MM/DD/YYYY", "03/05/2024", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"YYYY-MM-DD", "2024-03-05", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"DD.MM.YY", "05.03.24", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		// Go layouts are taken as they are
+		{"2006-01-02", "2024-03-05", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"Jan 2, 2006", "Mar 5, 2024", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := time.Parse(dateLayout(tt.format), tt.value)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s with layout %q: %s, %v; want %s", tt.value, dateLayout(tt.format), got, err, tt.want)
+		}
+	}
+}
+
+func TestParseStatementAmount(t *testing.T) {
+	tests := []struct {
+		in, sep string
+		want    Money
+		wantErr bool
+	}{
+		{"12.50", ".", 1250, false},
+		{"-12.50", ".", -1250, false},
+		{"1,234.56", ".", 123456, false},
+		{"1.234,56", ",", 123456, false},
+		{"1'234.50", ".", 123450, false},
+		{"1 234,50", ",", 123450, false},
+		{"(12.50)", ".", -1250, false},
+		{"12.50-", ".", -1250, false},
+		{"(-12.50)", ".", -1250, false},
+		{"Rp 1.500.000,00", ",", 150000000, false},
+		{"€3,20", ",", 320, false},
+		{"$-7", ".", -700, false},
+		{"1.234", ".", 0, true},
+		{"abc", ".", 0, true},
+		{"", ".", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatementAmount(tt.in, tt.sep)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStatementAmount(%q, %q) = %s, %v; want %s, wantErr %v", tt.in, tt.sep, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCSVRecord(t *testing.T) {
+	p := ImportProfile{Name: "Bank", HasHeader: true, DateColumn: "Date", DateFormat: "DD/MM/YYYY",
+		DebitColumn: "Debit", CreditColumn: "Credit", DescriptionColumn: "Description", DecimalSeparator: ","}
+	cols, err := resolveColumns(p, []string{"Date", " description ", "Debit", "Credit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		record  []string
+		name    string
+		amount  Money
+		skip    string
+		wantErr bool
+	}{
+		{[]string{"05/03/2024", "Shop", "12,50", ""}, "Shop", -1250, "", false},
+		{[]string{"05/03/2024", "Salary", "", "1.000,00"}, "Salary", 100000, "", false},
+		{[]string{"05/03/2024", "", "", "-3"}, "Imported transaction", 300, "", false},
+		{[]string{"05/03/2024", "Nothing", "", ""}, "", 0, "zero amount", false},
+		{[]string{" ", "", "", ""}, "", 0, "empty row", false},
+		{[]string{"2024-03-05", "Shop", "1", ""}, "", 0, "", true},
+		{[]string{"05/03/2024", "Shop", "x", ""}, "", 0, "", true},
+	}
+	for _, tt := range tests {
+		tx, skip, err := parseCSVRecord(p, cols, tt.record)
+		if (err != nil) != tt.wantErr || skip != tt.skip {
+			t.Errorf("%q: skip %q, error %v; want %q, wantErr %v", tt.record, skip, err, tt.skip, tt.wantErr)
+			continue
+		}
+		if tx == nil {
+			if tt.name != "" {
+				t.Errorf("%q: no transaction, want %q", tt.record, tt.name)
+			}
+			continue
+		}
+		if tx.Name != tt.name || tx.Amount != tt.amount || !tx.Date.Equal(mustDate(t, "2024-03-05")) {
+			t.Errorf("%q: %q %s %s, want %q %s on 2024-03-05", tt.record, tx.Name, tx.Amount, tx.Date, tt.name, tt.amount)
+		}
+	}
+	if _, err := resolveColumns(p, []string{"Date", "Debit"}); err == nil {
+		t.Errorf("resolveColumns with a missing column error = nil, want an error")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ofxTransaction is a STMTTRN aggregate with its fields as written.
+type ofxTransaction struct {
+	FITID  string
+	Posted string
+	Amount string
+	Name   string
+	Memo   string
+}
+
+// ofxStatement is one bank or credit card statement of an OFX file.
+type ofxStatement struct {
+	AccountID     string
+	Currency      string
+	Transactions  []ofxTransaction
+	LedgerBalance string
+	LedgerDate    string
+}
+
+// parseOFX reads the statements of an OFX or QFX file. Both the SGML flavour
+// of OFX 1.x, where leaf elements are not closed, and the XML flavour of OFX
+// 2.x are understood.
+func parseOFX(data string) ([]ofxStatement, error) {
+	start := strings.Index(strings.ToUpper(data), "<OFX>")
+	if start < 0 {
+		return nil, errors.New("not an OFX file")
+	}
+	data = data[start:]
+	var (
+		statements []ofxStatement
+		stack      []string
+	)
+	// within returns the innermost open aggregate among names.
+	within := func(names ...string) string {
+		for i := len(stack) - 1; i >= 0; i-- {
+			for _, name := range names {
+				if stack[i] == name {
+					return name
+				}
+			}
+		}
+		return ""
+	}
+	current := func() *ofxStatement {
+		if len(statements) == 0 {
+			return nil
+		}
+		return &statements[len(statements)-1]
+	}
+	for len(data) > 0 {
+		open := strings.IndexByte(data, '<')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(data[open:], '>')
+		if end < 0 {
+			return nil, errors.New("unterminated OFX tag")
+		}
+		tag := strings.ToUpper(strings.TrimSpace(data[open+1 : open+end]))
+		data = data[open+end+1:]
+		next := strings.IndexByte(data, '<')
+		if next < 0 {
+			next = len(data)
+		}
+		value := strings.TrimSpace(data[:next])
+		switch {
+		case tag == "" || tag[0] == '?' || tag[0] == '!':
+			continue
+		case tag[0] == '/':
+			// closes an aggregate and any unclosed leaves inside it; closing
+			// tags of XML leaves match no open aggregate and are ignored
+			name := tag[1:]
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == name {
+					stack = stack[:i]
+					break
+				}
+			}
+			continue
+		case value == "":
+			stack = append(stack, tag)
+			if tag == "STMTRS" || tag == "CCSTMTRS" {
+				statements = append(statements, ofxStatement{})
+			}
+			if tag == "STMTTRN" && within("STMTRS", "CCSTMTRS") != "" {
+				s := current()
+				s.Transactions = append(s.Transactions, ofxTransaction{})
+			}
+			continue
+		}
+		value = unescapeOFX(value)
+		s := current()
+		if s == nil {
+			continue
+		}
+		switch within("STMTTRN", "LEDGERBAL", "BANKACCTFROM", "CCACCTFROM", "STMTRS", "CCSTMTRS") {
+		case "STMTTRN":
+			t := &s.Transactions[len(s.Transactions)-1]
+			switch tag {
+			case "FITID":
+				t.FITID = value
+			case "DTPOSTED":
+				t.Posted = value
+			case "TRNAMT":
+				t.Amount = value
+			case "NAME":
+				t.Name = value
+			case "MEMO":
+				t.Memo = value
+			}
+		case "LEDGERBAL":
+			switch tag {
+			case "BALAMT":
+				s.LedgerBalance = value
+			case "DTASOF":
+				s.LedgerDate = value
+			}
+		case "BANKACCTFROM", "CCACCTFROM":
+			if tag == "ACCTID" {
+				s.AccountID = value
+			}
+		case "STMTRS", "CCSTMTRS":
+			if tag == "CURDEF" {
+				s.Currency = value
+			}
+		}
+	}
+	if len(statements) == 0 {
+		return nil, errors.New("OFX file contains no statement")
+	}
+	return statements, nil
+}
+
+func unescapeOFX(s string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&nbsp;", " ", "&amp;", "&").Replace(s)
+}
+
+// parseOFXDate reads the calendar date of an OFX datetime such as
+// "20240305", "20240305120000" or "20240305120000.000[-5:EST]".
+func parseOFXDate(s string) (time.Time, error) {
+	if len(s) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	t, err := time.Parse("20060102", s[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
+
+// parseOFXAmount reads an OFX amount; some banks write a decimal comma.
+func parseOFXAmount(s string) (Money, error) {
+	if !strings.Contains(s, ".") {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return ParseMoney(s)
+}
+
+// toTransaction turns a STMTTRN into a transaction to import.
+func (o ofxTransaction) toTransaction() (*Transaction, error) {
+	if o.FITID == "" {
+		return nil, errors.New("missing FITID")
+	}
+	date, err := parseOFXDate(o.Posted)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseOFXAmount(o.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q: %v", o.Amount, err)
+	}
+	name := o.Name
+	if name == "" {
+		name = o.Memo
+	}
+	if name == "" {
+		name = "Imported transaction"
+	}
+	if utf8.RuneCountInString(name) > 255 {
+		name = string([]rune(name)[:255])
+	}
+	fitID := o.FITID
+	return &Transaction{Name: name, Amount: amount, Date: date, FITID: &fitID}, nil
+}
+
+// BalanceCheck compares a statement's ledger balance with the stored balance
+// of the account it was imported into.
+type BalanceCheck struct {
+	StatementBalance Money      `json:"statement_balance"`
+	StatementDate    *time.Time `json:"statement_date,omitempty"`
+	AccountBalance   Money      `json:"account_balance"`
+	Difference       Money      `json:"difference"`
+	Matches          bool       `json:"matches"`
+}
+
+type OFXImportReport struct {
+	ImportReport
+	Balance *BalanceCheck `json:"balance,omitempty"`
+	// Warnings lists problems with the statement that did not stop the import.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ImportOFX books an OFX or QFX statement into an account. It expects a
+// multipart form with the file and an account_id; when the file holds several
+// statements, acctid picks the one to import by its bank account number.
+// Transactions are identified by their FITID, so overlapping statements can
+// be imported again safely. The report compares the statement's ledger
+// balance with the account balance after the import.
+func (h *Handler) ImportOFX(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var account Account
+	if err := h.DB.Where("id = ? AND user_id = ?", c.PostForm("account_id"), userID).First(&account).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account not found"})
+		return
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing OFX file"})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !utf8.Valid(raw) {
+		// OFX 1.x files are usually CHARSET:1252
+		if raw, err = charmap.Windows1252.NewDecoder().Bytes(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	statements, err := parseOFX(string(raw))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	statement := statements[0]
+	if acctID := c.PostForm("acctid"); acctID != "" {
+		found := false
+		for _, s := range statements {
+			if s.AccountID == acctID {
+				statement, found = s, true
+				break
+			}
+		}
+		if !found {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no statement for acctid " + acctID})
+			return
+		}
+	} else if len(statements) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file holds several statements, pick one with acctid"})
+		return
+	}
+	if currency, err := normalizeCurrency(statement.Currency); statement.Currency != "" && err == nil && currency != account.Currency {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("statement currency %s does not match account currency %s", statement.Currency, account.Currency)})
+		return
+	}
+	report := &OFXImportReport{ImportReport: ImportReport{Rows: []ImportRowResult{}}}
+	var candidates []importCandidate
+	for i, o := range statement.Transactions {
+		t, err := o.toTransaction()
+		if err != nil {
+			report.add(i+1, importFailed, err.Error(), 0)
+			continue
+		}
+		candidates = append(candidates, importCandidate{Row: i + 1, Transaction: *t})
+	}
+	if err := h.bookImport(userID, account, candidates, &report.ImportReport); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if statement.LedgerBalance != "" {
+		balance, err := parseOFXAmount(statement.LedgerBalance)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("invalid ledger balance %q", statement.LedgerBalance))
+		} else {
+			h.DB.Where("id = ?", account.ID).First(&account)
+			check := &BalanceCheck{
+				StatementBalance: balance,
+				AccountBalance:   account.Amount,
+				Difference:       account.Amount - balance,
+			}
+			check.Matches = check.Difference == 0
+			if date, err := parseOFXDate(statement.LedgerDate); err == nil {
+				check.StatementDate = &date
+			}
+			report.Balance = check
+			if !check.Matches {
+				report.Warnings = append(report.Warnings, fmt.Sprintf("account balance %s differs from the statement's ledger balance %s by %s", account.Amount, balance, check.Difference))
+			}
+		}
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const ofxSGML = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS></SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>USD
+<BANKACCTFROM><BANKID>121000248<ACCTID>1234567<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20240301<DTEND>20240331
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20240305120000.000[-5:EST]<TRNAMT>-12.50<FITID>A1<NAME>STARBUCKS &amp; CO<MEMO>card 1234</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20240310<TRNAMT>1500.00<FITID>A2<MEMO>Salary</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>2487.50<DTASOF>20240331</LEDGERBAL>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const ofxXML = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX>
+  <CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+    <CURDEF>EUR</CURDEF>
+    <CCACCTFROM><ACCTID>4111</ACCTID></CCACCTFROM>
+    <BANKTRANLIST>
+      <STMTTRN><DTPOSTED>20240102</DTPOSTED><TRNAMT>-3,20</TRNAMT><FITID>C1</FITID><NAME>Bakery</NAME></STMTTRN>
+    </BANKTRANLIST>
+    <LEDGERBAL><BALAMT>-3.20</BALAMT><DTASOF>20240131</DTASOF></LEDGERBAL>
+  </CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1>
+  <BANKMSGSRSV1><STMTTRNRS><STMTRS>
+    <CURDEF>EUR</CURDEF>
+    <BANKACCTFROM><ACCTID>9999</ACCTID></BANKACCTFROM>
+  </STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>`
+
+func TestParseOFX(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []ofxStatement
+	}{
+		{"sgml", ofxSGML, []ofxStatement{{
+			AccountID: "1234567",
+			Currency:  "USD",
+			Transactions: []ofxTransaction{
+				{FITID: "A1", Posted: "20240305120000.000[-5:EST]", Amount: "-12.50", Name: "STARBUCKS & CO", Memo: "card 1234"},
+				{FITID: "A2", Posted: "20240310", Amount: "1500.00", Memo: "Salary"},
+			},
+			LedgerBalance: "2487.50",
+			LedgerDate:    "20240331",
+		}}},
+		{"xml with two statements", ofxXML, []ofxStatement{
+			{
+				AccountID:     "4111",
+				Currency:      "EUR",
+				Transactions:  []ofxTransaction{{FITID: "C1", Posted: "20240102", Amount: "-3,20", Name: "Bakery"}},
+				LedgerBalance: "-3.20",
+				LedgerDate:    "20240131",
+			},
+			{AccountID: "9999", Currency: "EUR"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := parseOFX(tt.data)
+		if err != nil {
+			t.Errorf("%s: parseOFX error = %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseOFX =\n%+v\nwant\n%+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseOFXErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"date,amount\n2024-01-01,5",
+		"<OFX><SIGNONMSGSRSV1></SIGNONMSGSRSV1></OFX>",
+		"<OFX><BANKMSGSRSV1><STMTRS",
+	}
+	for _, data := range tests {
+		if _, err := parseOFX(data); err == nil {
+			t.Errorf("parseOFX(%q) error = nil, want an error", data)
+		}
+	}
+}
+
+func TestOFXTransactionToTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      ofxTransaction
+		want    Transaction
+		wantErr bool
+	}{
+		{"name", ofxTransaction{FITID: "1", Posted: "20240305120000", Amount: "-12.50", Name: "Shop", Memo: "memo"},
+			Transaction{Name: "Shop", Amount: -1250, Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)}, false},
+		{"memo without name", ofxTransaction{FITID: "2", Posted: "20240305", Amount: "7", Memo: "Refund"},
+			Transaction{Name: "Refund", Amount: 700, Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)}, false},
+		{"decimal comma", ofxTransaction{FITID: "3", Posted: "20240305", Amount: "-3,20"},
+			Transaction{Name: "Imported transaction", Amount: -320, Date: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)}, false},
+		{"missing FITID", ofxTransaction{Posted: "20240305", Amount: "1"}, Transaction{}, true},
+		{"bad date", ofxTransaction{FITID: "4", Posted: "2024-03", Amount: "1"}, Transaction{}, true},
+		{"sub-cent amount", ofxTransaction{FITID: "5", Posted: "20240305", Amount: "1.005"}, Transaction{}, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.in.toTransaction()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: toTransaction error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got.Name != tt.want.Name || got.Amount != tt.want.Amount || !got.Date.Equal(tt.want.Date) {
+			t.Errorf("%s: toTransaction = %q %s %s, want %q %s %s", tt.name,
+				got.Name, got.Amount, got.Date, tt.want.Name, tt.want.Amount, tt.want.Date)
+		}
+		if got.FITID == nil || *got.FITID != tt.in.FITID {
+			t.Errorf("%s: toTransaction FITID = %v, want %q", tt.name, got.FITID, tt.in.FITID)
+		}
+	}
+}
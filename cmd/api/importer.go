@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	importImported = "imported"
+	importSkipped  = "skipped"
+	importFailed   = "failed"
+)
+
+// ImportRowResult reports what happened to one line of an imported statement.
+type ImportRowResult struct {
+	Row           int    `json:"row"`
+	Status        string `json:"status"`
+	Message       string `json:"message,omitempty"`
+	TransactionID uint   `json:"transaction_id,omitempty"`
+	// Suggestion is the learned category given to a line no rule categorized.
+	Suggestion *CategorySuggestion `json:"suggestion,omitempty"`
+}
+
+type ImportReport struct {
+	Imported int               `json:"imported"`
+	Skipped  int               `json:"skipped"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+func (r *ImportReport) add(row int, status, message string, transactionID uint) {
+	switch status {
+	case importImported:
+		r.Imported++
+	case importSkipped:
+		r.Skipped++
+	case importFailed:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, ImportRowResult{Row: row, Status: status, Message: message, TransactionID: transactionID})
+}
+
+// importCandidate is a parsed statement line waiting to be booked.
+type importCandidate struct {
+	Row         int
+	Transaction Transaction
+}
+
+type importKey struct {
+	date   string
+	amount Money
+	name   string
+}
+
+// bookImport creates the candidates as transactions of account and applies
+// them to its balance, all in one database transaction. Candidates carrying a
+// bank FITID are skipped when the account already holds that FITID. Other
+// lines are skipped as already imported when the account already holds as
+// many transactions with the same date, amount and name as the statement has
+// seen so far, so re-importing a statement is harmless while genuine repeats
+// are kept. The user's rules run on every line; a line also counts as
+// already imported when a transaction carries the name it had before the
+// rules renamed it. Lines left uncategorized by the rules get the category
+// learned from the user's history when the classifier is confident enough.
+func (h *Handler) bookImport(userID uint, account Account, candidates []importCandidate, report *ImportReport) error {
+	var touches []budgetTouch
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		rules, err := loadRules(tx, userID)
+		if err != nil {
+			return err
+		}
+		learned, err := trainCategorizer(tx, userID)
+		if err != nil {
+			return err
+		}
+		// suggest gives an uncategorized line its learned category
+		suggest := func(t *Transaction) *CategorySuggestion {
+			if t.CategoryID != nil || len(t.Splits) > 0 {
+				return nil
+			}
+			suggestions := learned.suggest(t.Name, t.Amount)
+			if len(suggestions) == 0 || suggestions[0].Confidence < importSuggestionConfidence {
+				return nil
+			}
+			t.CategoryID = &suggestions[0].CategoryID
+			return &suggestions[0]
+		}
+		existing := map[importKey]int64{}
+		seen := map[importKey]int64{}
+		for _, cand := range candidates {
+			t := cand.Transaction
+			t.UserID = userID
+			t.AccountID = &account.ID
+			t.Currency = account.Currency
+			normalizeTransactionDates(&t)
+			statementName := t.Name
+			applyRules(rules, &t, false)
+			suggestion := suggest(&t)
+			if t.FITID != nil {
+				result := tx.Clauses(clause.OnConflict{
+					Columns:   []clause.Column{{Name: "account_id"}, {Name: "fitid"}},
+					DoNothing: true,
+				}).Create(&t)
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					report.add(cand.Row, importSkipped, "already imported", 0)
+					continue
+				}
+				if err := adjustBalance(tx, userID, t.AccountID, t.Amount); err != nil {
+					return err
+				}
+				report.add(cand.Row, importImported, "", t.ID)
+				report.Rows[len(report.Rows)-1].Suggestion = suggestion
+				touches = append(touches, transactionTouches(&t)...)
+				continue
+			}
+			key := importKey{t.Date.Format("2006-01-02"), t.Amount, statementName}
+			if _, ok := existing[key]; !ok {
+				var count int64
+				if err := tx.Model(&Transaction{}).
+					Where("user_id = ? AND account_id = ? AND date = ? AND amount = ? AND name IN ?", userID, account.ID, t.Date, t.Amount, []string{statementName, t.Name}).
+					Count(&count).Error; err != nil {
+					return err
+				}
+				existing[key] = count
+			}
+			seen[key]++
+			if seen[key] <= existing[key] {
+				report.add(cand.Row, importSkipped, "already imported", 0)
+				continue
+			}
+			if err := tx.Create(&t).Error; err != nil {
+				return err
+			}
+			if err := adjustBalance(tx, userID, t.AccountID, t.Amount); err != nil {
+				return err
+			}
+			report.add(cand.Row, importImported, "", t.ID)
+			report.Rows[len(report.Rows)-1].Suggestion = suggestion
+			touches = append(touches, transactionTouches(&t)...)
+		}
+		return nil
+	})
+	sort.SliceStable(report.Rows, func(i, j int) bool { return report.Rows[i].Row < report.Rows[j].Row })
+	if err == nil {
+		h.checkBudgetAlerts(userID, touches)
+	}
+	return err
+}
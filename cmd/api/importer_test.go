@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestBookImport(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	account := testAccount(t, db, user.ID, accountChecking, 0)
+	rule := Rule{Name: "starbucks", NameContains: "pos 1 starbucks", SetName: "Starbucks", UserID: user.ID}
+	if err := db.Create(&rule).Error; err != nil {
+		t.Fatal(err)
+	}
+	fitID := "F1"
+	line := func(row int, date string, amount Money, name string) importCandidate {
+		return importCandidate{Row: row, Transaction: Transaction{Name: name, Amount: amount, Date: mustDate(t, date)}}
+	}
+	statement := []importCandidate{
+		line(2, "2024-03-05", -500, "Coffee"),
+		// the same coffee twice that day
+		line(3, "2024-03-05", -500, "Coffee"),
+		line(4, "2024-03-05", -700, "POS 1 STARBUCKS"),
+		line(5, "2024-03-06", -100, "Fee"),
+	}
+	statement[3].Transaction.FITID = &fitID
+
+	tests := []struct {
+		name              string
+		candidates        []importCandidate
+		imported, skipped int
+	}{
+		{"first import", statement, 4, 0},
+		// the renamed line is recognized by its statement name
+		{"re-import", statement, 0, 4},
+		{"a third coffee", append(append([]importCandidate{}, statement...), line(6, "2024-03-05", -500, "Coffee")), 1, 4},
+	}
+	for _, tt := range tests {
+		var report ImportReport
+		if err := h.bookImport(user.ID, account, tt.candidates, &report); err != nil {
+			t.Fatalf("%s: bookImport error = %v", tt.name, err)
+		}
+		if report.Imported != tt.imported || report.Skipped != tt.skipped || report.Failed != 0 {
+			t.Errorf("%s: imported %d, skipped %d, failed %d; want %d, %d, 0", tt.name,
+				report.Imported, report.Skipped, report.Failed, tt.imported, tt.skipped)
+		}
+		for i := 1; i < len(report.Rows); i++ {
+			if report.Rows[i].Row < report.Rows[i-1].Row {
+				t.Errorf("%s: rows out of order: %+v", tt.name, report.Rows)
+				break
+			}
+		}
+	}
+	db.First(&account, account.ID)
+	if account.Amount != -2300 {
+		t.Errorf("balance = %s, want -23.00", account.Amount)
+	}
+	var renamed int64
+	db.Model(&Transaction{}).Where("account_id = ? AND name = ?", account.ID, "Starbucks").Count(&renamed)
+	if renamed != 1 {
+		t.Errorf("%d transactions renamed by the rule, want 1", renamed)
+	}
+}
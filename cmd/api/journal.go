@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	journalOpeningAccount    = "Equity:Opening-Balances"
+	journalUnassignedAccount = "Assets:Unassigned"
+	journalFeesAccount       = "Expenses:Transfer-Fees"
+)
+
+// journalPosting moves Amount of Currency into Account. A posting with a
+// price converts into another currency, e.g. the source side of a transfer
+// between accounts of different currencies.
+type journalPosting struct {
+	Account       string
+	Amount        Money
+	Currency      string
+	PriceAmount   Money
+	PriceCurrency string
+	// Assert, when set, is the balance Account must have after the posting.
+	Assert *Money
+}
+
+type journalEntry struct {
+	Date     time.Time
+	Payee    string
+	Postings []journalPosting
+}
+
+// journalFolding spells out the letters that do not decompose into an ASCII
+// letter and accents.
+var journalFolding = strings.NewReplacer(
+	"ß", "ss", "Æ", "AE", "æ", "ae", "Ø", "O", "ø", "o", "Œ", "OE", "œ", "oe",
+	"Đ", "D", "đ", "d", "Ł", "L", "ł", "l", "Þ", "Th", "þ", "th",
+)
+
+// journalAccountName turns a name into one account name component valid in
+// both ledger and beancount: ASCII words joined by dashes, starting with an
+// upper case letter or digit.
+func journalAccountName(name, fallback string) string {
+	// accents are stripped from their letters and letters with no ASCII
+	// form dropped, anything else that is not an ASCII letter or digit
+	// separates words
+	ascii := strings.Map(func(r rune) rune {
+		switch {
+		case r <= unicode.MaxASCII:
+			return r
+		case unicode.IsLetter(r) || unicode.Is(unicode.Mn, r):
+			return -1
+		}
+		return ' '
+	}, norm.NFD.String(journalFolding.Replace(name)))
+	var words []string
+	for _, word := range strings.FieldsFunc(ascii, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words = append(words, strings.ToUpper(word[:1])+word[1:])
+	}
+	if len(words) == 0 {
+		return fallback
+	}
+	return strings.Join(words, "-")
+}
+
+// buildJournal loads the user's accounts, categories, transactions and
+// transfers and turns them into journal entries.
+func (h *Handler) buildJournal(userID uint) ([]journalEntry, error) {
+	var accounts []Account
+	var categories []Category
+	var transactions []Transaction
+	var transfers []Transfer
+	if err := h.DB.Where("user_id = ?", userID).Order("id").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	h.DB.Where("user_id = ?", userID).Order("id").Find(&categories)
+	if err := h.DB.Where("user_id = ?", userID).Preload("Splits").Order("date, id").Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	if err := h.DB.Where("user_id = ?", userID).Order("date, id").Find(&transfers).Error; err != nil {
+		return nil, err
+	}
+	return journalEntries(accounts, categories, transactions, transfers, dateOnly(time.Now())), nil
+}
+
+// journalEntries turns transactions and transfers into journal entries,
+// preceded by an opening balance entry that makes every account end at its
+// stored balance and followed by balance assertions dated the day after the
+// last entry, or after today when that is later. Accounts or categories whose
+// names come out the same get their ID appended.
+func journalEntries(accounts []Account, categories []Category, transactions []Transaction, transfers []Transfer, today time.Time) []journalEntry {
+	accountNames := map[uint]string{}
+	taken := map[string]bool{}
+	for _, acc := range accounts {
+		kind := "Assets:"
+		if isLiability(acc.Type) {
+			kind = "Liabilities:"
+		}
+		name := kind + journalAccountName(acc.BankName, fmt.Sprintf("Account-%d", acc.ID))
+		if taken[name] {
+			name = fmt.Sprintf("%s-%d", name, acc.ID)
+		}
+		taken[name] = true
+		accountNames[acc.ID] = name
+	}
+	categoryNames := map[uint]string{}
+	taken = map[string]bool{"Uncategorized": true, "Transfer-Fees": true}
+	for _, cat := range categories {
+		name := journalAccountName(cat.Name, fmt.Sprintf("Category-%d", cat.ID))
+		if taken[name] {
+			name = fmt.Sprintf("%s-%d", name, cat.ID)
+		}
+		taken[name] = true
+		categoryNames[cat.ID] = name
+	}
+	assetAccount := func(id *uint) string {
+		if id == nil {
+			return journalUnassignedAccount
+		}
+		return accountNames[*id]
+	}
+	// lines taking money out of an account are expenses, the others income
+	categoryAccount := func(id *uint, amount Money) string {
+		name := "Uncategorized"
+		if id != nil && categoryNames[*id] != "" {
+			name = categoryNames[*id]
+		}
+		if amount < 0 {
+			return "Expenses:" + name
+		}
+		return "Income:" + name
+	}
+
+	var entries []journalEntry
+	for _, t := range transactions {
+		entry := journalEntry{Date: t.Date, Payee: t.Name, Postings: []journalPosting{
+			{Account: assetAccount(t.AccountID), Amount: t.Amount, Currency: t.Currency},
+		}}
+		if len(t.Splits) == 0 {
+			entry.Postings = append(entry.Postings, journalPosting{Account: categoryAccount(t.CategoryID, t.Amount), Amount: -t.Amount, Currency: t.Currency})
+		}
+		for _, s := range t.Splits {
+			entry.Postings = append(entry.Postings, journalPosting{Account: categoryAccount(s.CategoryID, s.Amount), Amount: -s.Amount, Currency: t.Currency})
+		}
+		entries = append(entries, entry)
+	}
+	currencies := map[uint]string{}
+	for _, acc := range accounts {
+		currencies[acc.ID] = acc.Currency
+	}
+	for _, t := range transfers {
+		if t.FromAccountID == nil || t.ToAccountID == nil {
+			continue
+		}
+		from, to := currencies[*t.FromAccountID], currencies[*t.ToAccountID]
+		payee := t.Note
+		if payee == "" {
+			payee = "Transfer"
+		}
+		source := journalPosting{Account: accountNames[*t.FromAccountID], Amount: -t.Amount, Currency: from}
+		if from != to {
+			source.PriceAmount, source.PriceCurrency = t.ToAmount, to
+		}
+		entry := journalEntry{Date: t.Date, Payee: payee, Postings: []journalPosting{
+			source,
+			{Account: accountNames[*t.ToAccountID], Amount: t.ToAmount, Currency: to},
+		}}
+		if t.Fee != 0 {
+			entry.Postings = append(entry.Postings,
+				journalPosting{Account: accountNames[*t.FromAccountID], Amount: -t.Fee, Currency: from},
+				journalPosting{Account: journalFeesAccount, Amount: t.Fee, Currency: from})
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date.Before(entries[j].Date) })
+
+	first, last := today, today
+	if len(entries) > 0 {
+		first = entries[0].Date
+		if d := entries[len(entries)-1].Date; d.After(last) {
+			last = d
+		}
+	}
+	moved := map[string]Money{}
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			moved[p.Account] += p.Amount
+		}
+	}
+	opening := journalEntry{Date: first, Payee: "Opening balances"}
+	closing := journalEntry{Date: last.AddDate(0, 0, 1), Payee: "Balance assertions"}
+	for _, acc := range accounts {
+		name := accountNames[acc.ID]
+		if diff := acc.Amount - moved[name]; diff != 0 {
+			opening.Postings = append(opening.Postings,
+				journalPosting{Account: name, Amount: diff, Currency: acc.Currency},
+				journalPosting{Account: journalOpeningAccount, Amount: -diff, Currency: acc.Currency})
+		}
+		balance := acc.Amount
+		closing.Postings = append(closing.Postings, journalPosting{Account: name, Currency: acc.Currency, Assert: &balance})
+	}
+	if len(opening.Postings) > 0 {
+		entries = append([]journalEntry{opening}, entries...)
+	}
+	if len(closing.Postings) > 0 {
+		entries = append(entries, closing)
+	}
+	return entries
+}
+
+// journalPayee keeps a description on one line and free of sequences the
+// journal formats would read as comments or separators.
+func journalPayee(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.NewReplacer(";", ",", "|", "/").Replace(s)
+}
+
+func writeLedger(w *bufio.Writer, entries []journalEntry) {
+	for _, name := range journalAccounts(entries) {
+		fmt.Fprintf(w, "account %s\n", name)
+	}
+	for _, e := range entries {
+		fmt.Fprintf(w, "\n%s %s\n", e.Date.Format("2006-01-02"), journalPayee(e.Payee))
+		for _, p := range e.Postings {
+			fmt.Fprintf(w, "    %-50s  %s %s", p.Account, p.Amount, p.Currency)
+			if p.PriceCurrency != "" {
+				fmt.Fprintf(w, " @@ %s %s", p.PriceAmount.Abs(), p.PriceCurrency)
+			}
+			if p.Assert != nil {
+				fmt.Fprintf(w, " = %s %s", *p.Assert, p.Currency)
+			}
+			w.WriteString("\n")
+		}
+	}
+}
+
+func writeBeancount(w *bufio.Writer, entries []journalEntry, base string) {
+	fmt.Fprintf(w, "option \"operating_currency\" \"%s\"\n\n", base)
+	opened := "1970-01-01"
+	if len(entries) > 0 {
+		opened = entries[0].Date.Format("2006-01-02")
+	}
+	for _, name := range journalAccounts(entries) {
+		fmt.Fprintf(w, "%s open %s\n", opened, name)
+	}
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	for _, e := range entries {
+		date := e.Date.Format("2006-01-02")
+		var asserts []journalPosting
+		var postings []journalPosting
+		for _, p := range e.Postings {
+			if p.Assert != nil {
+				asserts = append(asserts, p)
+			} else {
+				postings = append(postings, p)
+			}
+		}
+		for _, p := range asserts {
+			fmt.Fprintf(w, "\n%s balance %s %s %s", date, p.Account, *p.Assert, p.Currency)
+		}
+		if len(asserts) > 0 {
+			w.WriteString("\n")
+		}
+		if len(postings) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s * \"%s\"\n", date, quote.Replace(journalPayee(e.Payee)))
+		for _, p := range postings {
+			fmt.Fprintf(w, "  %-50s  %s %s", p.Account, p.Amount, p.Currency)
+			if p.PriceCurrency != "" {
+				fmt.Fprintf(w, " @@ %s %s", p.PriceAmount.Abs(), p.PriceCurrency)
+			}
+			w.WriteString("\n")
+		}
+	}
+}
+
+// journalAccounts lists every account the entries post to.
+func journalAccounts(entries []journalEntry) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			if !seen[p.Account] {
+				seen[p.Account] = true
+				names = append(names, p.Account)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExportJournal writes the user's books as a plain-text accounting journal:
+// format=ledger (the default, also read by hledger) or format=beancount.
+// Accounts become Assets:<bank name>, or Liabilities:<bank name> for credit
+// cards and loans, categories Expenses:<name> or Income:<name>. An opening
+// balance entry reconciles every account with its stored balance, which the
+// closing balance assertions let the tools verify.
+func (h *Handler) ExportJournal(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	format := c.DefaultQuery("format", "ledger")
+	if format != "ledger" && format != "beancount" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be ledger or beancount"})
+		return
+	}
+	entries, err := h.buildJournal(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="finance-manager.%s"`, format))
+	c.Status(http.StatusOK)
+	w := bufio.NewWriter(c.Writer)
+	if format == "beancount" {
+		writeBeancount(w, entries, h.baseCurrency(userID))
+	} else {
+		writeLedger(w, entries)
+	}
+	w.Flush()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJournalAccountName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Bank Central Asia", "Bank-Central-Asia"},
+		{"cash & wallet", "Cash-Wallet"},
+		{"Café Müller", "Cafe-Muller"},
+		{"Straße", "Strasse"},
+		{"Ørsted Øre", "Orsted-Ore"},
+		{"Łódź 2", "Lodz-2"},
+		{"食费", "Fallback"},
+		{"Makan 食费", "Makan"},
+		{"١٢٣ Savings", "Savings"},
+		{"  ---  ", "Fallback"},
+	}
+	for _, tt := range tests {
+		if got := journalAccountName(tt.name, "Fallback"); got != tt.want {
+			t.Errorf("journalAccountName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestJournalEntries(t *testing.T) {
+	ids := []uint{1, 2, 3}
+	accounts := []Account{
+		{ID: 1, BankName: "Café Müller", Type: accountChecking, Amount: 100000, Currency: "IDR"},
+		{ID: 2, BankName: "Cafe Muller", Type: accountSavings, Amount: 0, Currency: "IDR"},
+		{ID: 3, BankName: "Visa", Type: accountCreditCard, Amount: -5000, Currency: "IDR"},
+	}
+	categories := []Category{{ID: 1, Name: "Food"}, {ID: 2, Name: "food"}, {ID: 3, Name: "Uncategorized"}}
+	transactions := []Transaction{
+		{Name: "Lunch", Amount: -2000, Currency: "IDR", Date: mustDate(t, "2024-03-05"), AccountID: &ids[0], CategoryID: &ids[1]},
+		{Name: "Refund", Amount: 500, Currency: "IDR", Date: mustDate(t, "2024-03-06"), AccountID: &ids[0], CategoryID: &ids[2]},
+	}
+	transfers := []Transfer{
+		{FromAccountID: &ids[0], ToAccountID: &ids[1], Amount: 3000, ToAmount: 3000, Date: mustDate(t, "2024-03-10")},
+	}
+
+	entries := journalEntries(accounts, categories, transactions, transfers, mustDate(t, "2024-03-01"))
+	if len(entries) != 5 {
+		t.Fatalf("got %d entries, want 5", len(entries))
+	}
+	opening, closing := entries[0], entries[len(entries)-1]
+
+	// the opening balances make up for what the entries move: 1000.00 less
+	// the -20.00, +5.00 and -30.00 moved out, the 30.00 moved in and the card
+	// debt that has no entries
+	if !opening.Date.Equal(mustDate(t, "2024-03-05")) {
+		t.Errorf("opening date = %s, want 2024-03-05", opening.Date.Format("2006-01-02"))
+	}
+	wantOpening := []journalPosting{
+		{Account: "Assets:Cafe-Muller", Amount: 104500, Currency: "IDR"},
+		{Account: journalOpeningAccount, Amount: -104500, Currency: "IDR"},
+		{Account: "Assets:Cafe-Muller-2", Amount: -3000, Currency: "IDR"},
+		{Account: journalOpeningAccount, Amount: 3000, Currency: "IDR"},
+		{Account: "Liabilities:Visa", Amount: -5000, Currency: "IDR"},
+		{Account: journalOpeningAccount, Amount: 5000, Currency: "IDR"},
+	}
+	if !reflect.DeepEqual(opening.Postings, wantOpening) {
+		t.Errorf("opening postings = %+v, want %+v", opening.Postings, wantOpening)
+	}
+
+	// the categories that collide keep apart
+	if got := entries[1].Postings[1].Account; got != "Expenses:Food-2" {
+		t.Errorf("lunch category = %q, want Expenses:Food-2", got)
+	}
+	if got := entries[2].Postings[1].Account; got != "Income:Uncategorized-3" {
+		t.Errorf("refund category = %q, want Income:Uncategorized-3", got)
+	}
+
+	if !closing.Date.Equal(mustDate(t, "2024-03-11")) {
+		t.Errorf("closing date = %s, want 2024-03-11", closing.Date.Format("2006-01-02"))
+	}
+	if len(closing.Postings) != len(accounts) {
+		t.Fatalf("got %d assertions, want %d", len(closing.Postings), len(accounts))
+	}
+	for i, p := range closing.Postings {
+		if p.Assert == nil || *p.Assert != accounts[i].Amount || p.Amount != 0 {
+			t.Errorf("assertion %s = %+v, want balance %s", p.Account, p, accounts[i].Amount)
+		}
+	}
+
+	// without entries the books open and close around today
+	entries = journalEntries(accounts[:1], nil, nil, nil, mustDate(t, "2024-03-01"))
+	if len(entries) != 2 || !entries[0].Date.Equal(mustDate(t, "2024-03-01")) || !entries[1].Date.Equal(mustDate(t, "2024-03-02")) {
+		t.Errorf("entries without activity = %+v", entries)
+	}
+}
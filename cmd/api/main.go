@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -18,14 +21,18 @@ import (
 )
 
 type User struct {
-	ID           uint      `gorm:"primaryKey" json:"id"`
-	Name         string    `gorm:"size:500;not null" json:"name"`
-	Email        string    `gorm:"size:500;uniqueIndex;not null" json:"email"`
-	Password     string    `gorm:"size:500;not null" json:"-"`
-	RefreshToken string    `gorm:"type:text" json:"-"`
-	AccessToken  string    `gorm:"type:text" json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	Name         string `gorm:"size:500;not null" json:"name"`
+	Email        string `gorm:"size:500;uniqueIndex;not null" json:"email"`
+	Password     string `gorm:"size:500;not null" json:"-"`
+	RefreshToken string `gorm:"type:text" json:"-"`
+	AccessToken  string `gorm:"type:text" json:"-"`
+	BaseCurrency string `gorm:"size:3;not null;default:IDR" json:"base_currency"`
+	// EnvelopeStart is the first month of envelope budgeting, nil for
+	// standard budgets.
+	EnvelopeStart *time.Time `gorm:"type:date" json:"envelope_start"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 }
 type Category struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -35,41 +42,73 @@ type Category struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 type Account struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	BankName  string    `gorm:"size:255;not null" json:"bank_name"`
-	Amount    float64   `gorm:"type:decimal(12,2);not null;default:0" json:"amount"`
-	UserID    uint      `gorm:"not null;index" json:"user_id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	BankName string `gorm:"size:255;not null" json:"bank_name"`
+	// Type is checking, savings, cash, credit_card, loan, investment or asset.
+	Type         string    `gorm:"size:20;not null;default:checking" json:"type"`
+	Amount       Money     `gorm:"type:decimal(12,2);not null;default:0" json:"amount"`
+	Currency     string    `gorm:"size:3;not null;default:IDR" json:"currency"`
+	Institution  string    `gorm:"size:255" json:"institution"`
+	CreditLimit  *Money    `gorm:"type:decimal(12,2)" json:"credit_limit"`
+	StatementDay *int      `json:"statement_day"`
+	InterestRate *float64  `gorm:"type:decimal(7,4)" json:"interest_rate"`
+	UserID       uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 type Transaction struct {
-	ID         uint      `gorm:"primaryKey" json:"id"`
-	Name       string    `gorm:"size:255;not null" json:"name"`
-	Amount     float64   `gorm:"type:decimal(12,2);not null" json:"amount"`
-	UserID     uint      `gorm:"not null;index" json:"user_id"`
-	CategoryID *uint     `gorm:"index" json:"category_id"`
-	Category   *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
-	AccountID  *uint     `gorm:"index" json:"account_id"`
-	Account    *Account  `gorm:"foreignKey:AccountID" json:"account,omitempty"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID         uint               `gorm:"primaryKey" json:"id"`
+	Name       string             `gorm:"size:255;not null" json:"name"`
+	Amount     Money              `gorm:"type:decimal(12,2);not null" json:"amount"`
+	Currency   string             `gorm:"size:3;not null;default:IDR" json:"currency"`
+	Date       time.Time          `gorm:"type:date;not null" json:"date"`
+	ValueDate  *time.Time         `gorm:"type:date" json:"value_date"`
+	FITID      *string            `gorm:"column:fitid;size:255" json:"fitid,omitempty"`
+	UserID     uint               `gorm:"not null;index" json:"user_id"`
+	CategoryID *uint              `gorm:"index" json:"category_id"`
+	Category   *Category          `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	AccountID  *uint              `gorm:"index" json:"account_id"`
+	Account    *Account           `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	Splits     []TransactionSplit `gorm:"foreignKey:TransactionID" json:"splits,omitempty"`
+	Tags       Tags               `gorm:"type:text" json:"tags"`
+	// Status is uncleared, cleared or reconciled; reconciled transactions
+	// cannot be changed until unlocked.
+	Status    string    `gorm:"size:20;not null;default:uncleared" json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 type Budget struct {
-	ID         uint      `gorm:"primaryKey" json:"id"`
-	CategoryID uint      `gorm:"not null;index" json:"category_id"`
-	Category   *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
-	Amount     float64   `gorm:"type:decimal(12,2);not null" json:"amount"`
-	Criteria   string    `gorm:"size:50;not null" json:"criteria"`
-	UserID     uint      `gorm:"not null;index" json:"user_id"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Name string `gorm:"size:255" json:"name"`
+	// CategoryID is accepted from clients that budget a single category and
+	// stands for category_ids: [category_id]; it is never stored.
+	CategoryID  *uint      `gorm:"-" json:"category_id,omitempty"`
+	CategoryIDs IDList     `gorm:"column:category_ids;type:text" json:"category_ids"`
+	AccountIDs  IDList     `gorm:"column:account_ids;type:text" json:"account_ids"`
+	Amount      Money      `gorm:"type:decimal(12,2);not null" json:"amount"`
+	Criteria    string     `gorm:"size:50;not null" json:"criteria"`
+	WeekStart   string     `gorm:"size:10" json:"week_start,omitempty"`
+	AnchorDay   int        `gorm:"not null" json:"anchor_day,omitempty"`
+	StartsOn    *time.Time `gorm:"type:date" json:"starts_on,omitempty"`
+	EndsOn      *time.Time `gorm:"type:date" json:"ends_on,omitempty"`
+	Rollover    bool       `gorm:"not null" json:"rollover"`
+	// AlertThresholds are the percentages of the budget at which a
+	// notification is raised.
+	AlertThresholds Percentages `gorm:"type:text" json:"alert_thresholds"`
+	UserID          uint        `gorm:"not null;index" json:"user_id"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
 }
 type ScheduledTransaction struct {
 	ID         uint      `gorm:"primaryKey" json:"id"`
 	Name       string    `gorm:"size:255;not null" json:"name"`
-	Amount     float64   `gorm:"type:decimal(12,2);not null" json:"amount"`
+	Amount     Money     `gorm:"type:decimal(12,2);not null" json:"amount"`
 	Repetition string    `gorm:"size:50;not null" json:"repetition"`
+	RRule      string    `gorm:"column:rrule;size:500" json:"rrule"`
+	StartsAt   time.Time `gorm:"not null" json:"starts_at"`
+	ExDates    DateList  `gorm:"column:exdates;type:text" json:"exdates"`
 	RepeatAt   time.Time `gorm:"not null" json:"repeat_at"`
+	Completed  bool      `gorm:"not null" json:"completed"`
 	UserID     uint      `gorm:"not null;index" json:"user_id"`
 	CategoryID *uint     `gorm:"index" json:"category_id"`
 	Category   *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
@@ -150,31 +189,114 @@ func main() {
 		protected.POST("/categories", handler.CreateCategory)
 		protected.PUT("/categories/:id", handler.UpdateCategory)
 		protected.DELETE("/categories/:id", handler.DeleteCategory)
+		protected.GET("/categories/suggest", handler.SuggestCategory)
 		protected.GET("/transactions", handler.GetTransactions)
 		protected.POST("/transactions", handler.CreateTransaction)
 		protected.PUT("/transactions/:id", handler.UpdateTransaction)
 		protected.DELETE("/transactions/:id", handler.DeleteTransaction)
+		protected.GET("/transactions/duplicates", handler.GetDuplicateTransactions)
+		protected.POST("/transactions/merge", handler.MergeTransactions)
+		protected.PUT("/transactions/:id/status", handler.SetTransactionStatus)
+		protected.POST("/transactions/:id/unlock", handler.UnlockTransaction)
 		protected.GET("/dashboard/stats", handler.GetDashboardStats)
 		protected.GET("/accounts", handler.GetAccounts)
 		protected.POST("/accounts", handler.CreateAccount)
 		protected.PUT("/accounts/:id", handler.UpdateAccount)
 		protected.DELETE("/accounts/:id", handler.DeleteAccount)
+		protected.GET("/accounts/:id/reconciliation", handler.GetReconciliationStatus)
+		protected.POST("/accounts/:id/reconcile", handler.ReconcileAccount)
+		protected.GET("/accounts/:id/reconciliations", handler.GetReconciliations)
 		protected.GET("/budgets", handler.GetBudgets)
 		protected.POST("/budgets", handler.CreateBudget)
 		protected.PUT("/budgets/:id", handler.UpdateBudget)
 		protected.DELETE("/budgets/:id", handler.DeleteBudget)
 		protected.POST("/budgets/check", handler.CheckBudgetExceeded)
+		protected.GET("/budgets/:id/history", handler.GetBudgetHistory)
+		protected.GET("/envelopes", handler.GetEnvelopes)
+		protected.PUT("/envelopes/:category_id/:month", handler.AssignEnvelope)
+		protected.POST("/envelopes/move", handler.MoveEnvelopeMoney)
+		protected.GET("/notifications", handler.GetNotifications)
+		protected.PUT("/notifications/:id", handler.UpdateNotification)
+		protected.POST("/notifications/read-all", handler.ReadAllNotifications)
+		protected.DELETE("/notifications/:id", handler.DeleteNotification)
+		protected.GET("/rules", handler.GetRules)
+		protected.POST("/rules", handler.CreateRule)
+		protected.PUT("/rules/:id", handler.UpdateRule)
+		protected.DELETE("/rules/:id", handler.DeleteRule)
+		protected.POST("/rules/apply", handler.ApplyRules)
 		protected.GET("/scheduled", handler.GetScheduledTransactions)
 		protected.POST("/scheduled", handler.CreateScheduledTransaction)
 		protected.PUT("/scheduled/:id", handler.UpdateScheduledTransaction)
 		protected.DELETE("/scheduled/:id", handler.DeleteScheduledTransaction)
 		protected.POST("/scheduled/process", handler.ProcessScheduledTransactions)
+		protected.POST("/scheduled/preview", handler.PreviewSchedule)
+		protected.GET("/scheduled/:id/preview", handler.PreviewScheduledTransaction)
+		protected.GET("/scheduled/:id/exceptions", handler.GetScheduledExceptions)
+		protected.PUT("/scheduled/:id/occurrences/:date", handler.SetScheduledException)
+		protected.DELETE("/scheduled/:id/occurrences/:date", handler.DeleteScheduledException)
+		protected.GET("/forecast", handler.GetForecast)
+		protected.GET("/transfers", handler.GetTransfers)
+		protected.POST("/transfers", handler.CreateTransfer)
+		protected.PUT("/transfers/:id", handler.UpdateTransfer)
+		protected.DELETE("/transfers/:id", handler.DeleteTransfer)
+		protected.GET("/exchange-rates", handler.GetExchangeRates)
+		protected.POST("/exchange-rates/import", handler.ImportExchangeRates)
+		protected.GET("/settings", handler.GetSettings)
+		protected.PUT("/settings", handler.UpdateSettings)
+		protected.GET("/import-profiles", handler.GetImportProfiles)
+		protected.POST("/import-profiles", handler.CreateImportProfile)
+		protected.PUT("/import-profiles/:id", handler.UpdateImportProfile)
+		protected.DELETE("/import-profiles/:id", handler.DeleteImportProfile)
+		protected.POST("/import/csv", handler.ImportCSV)
+		protected.POST("/import/ofx", handler.ImportOFX)
+		protected.GET("/export", handler.ExportArchive)
+		protected.GET("/export/journal", handler.ExportJournal)
+		protected.POST("/import/archive", handler.ImportArchive)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	schedulerDone := make(chan struct{})
+	if interval := schedulerInterval(); interval > 0 {
+		log.Printf("Scheduler posting due scheduled transactions every %s", interval)
+		go func() {
+			defer close(schedulerDone)
+			handler.RunScheduler(ctx, interval)
+		}()
+	} else {
+		log.Println("Scheduler disabled")
+		close(schedulerDone)
 	}
-	log.Printf("Server starting on port %s...", port)
-	log.Printf("Open http://localhost:%s in your browser", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		log.Printf("Server starting on port %s...", port)
+		log.Printf("Open http://localhost:%s in your browser", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+	}()
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown: %v", err)
 	}
+	<-schedulerDone
+}
+
+// schedulerInterval reads SCHEDULER_INTERVAL, a Go duration such as "30s" or
+// "5m"; "0" or "off" disables the background scheduler.
+func schedulerInterval() time.Duration {
+	value := getEnv("SCHEDULER_INTERVAL", defaultSchedulerInterval.String())
+	if value == "off" || value == "0" {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid SCHEDULER_INTERVAL %q, using %s", value, defaultSchedulerInterval)
+		return defaultSchedulerInterval
+	}
+	return interval
 }
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -444,25 +566,58 @@ func (h *Handler) DeleteCategory(c *gin.Context) {
 	}
 	c.Status(http.StatusNoContent)
 }
-func (h *Handler) GetTransactions(c *gin.Context) {
-	userID := c.GetUint("user_id")
-	var transactions []Transaction
-	h.DB.Where("user_id = ?", userID).Preload("Category").Preload("Account").Order("created_at DESC").Find(&transactions)
-	c.JSON(http.StatusOK, transactions)
-}
 func (h *Handler) CreateTransaction(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var req Transaction
-	c.ShouldBindJSON(&req)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	req.UserID = userID
-	h.DB.Transaction(func(tx *gorm.DB) error {
-		tx.Create(&req)
+	req.FITID = nil
+	currency, err := h.transactionCurrency(h.DB, userID, req.AccountID, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Currency = currency
+	if req.Date.IsZero() {
+		req.Date = time.Now()
+	}
+	normalizeTransactionDates(&req)
+	if req.Tags, err = normalizeTags(req.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Status, err = normalizeStatus(req.Status); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rules, err := loadRules(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	applyRules(rules, &req, false)
+	if err := h.validateSplits(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&req).Error; err != nil {
+			return err
+		}
 		if req.AccountID != nil {
 			tx.Model(&Account{}).Where("id = ? AND user_id = ?", *req.AccountID, userID).
 				UpdateColumn("amount", gorm.Expr("amount + ?", req.Amount))
 		}
 		return nil
 	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.checkBudgetAlerts(userID, transactionTouches(&req))
 	c.JSON(http.StatusCreated, req)
 }
 func (h *Handler) UpdateTransaction(c *gin.Context) {
@@ -472,10 +627,47 @@ func (h *Handler) UpdateTransaction(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
-	oldAmount, oldAccountID := transaction.Amount, transaction.AccountID
-	c.ShouldBindJSON(&transaction)
+	if transaction.Status == transactionReconciled {
+		c.JSON(http.StatusConflict, gin.H{"error": errReconciled.Error()})
+		return
+	}
+	oldAmount, oldAccountID, oldCurrency := transaction.Amount, transaction.AccountID, transaction.Currency
+	fitID, status := transaction.FITID, transaction.Status
+	before := Transaction{CategoryID: transaction.CategoryID, Date: transaction.Date}
+	h.DB.Where("transaction_id = ?", transaction.ID).Find(&before.Splits)
+	transaction.Currency = ""
+	if err := c.ShouldBindJSON(&transaction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	transaction.UserID = userID
-	h.DB.Transaction(func(tx *gorm.DB) error {
+	// the bank's FITID only ever comes from a statement import, the status
+	// changes through its own endpoint
+	transaction.FITID, transaction.Status = fitID, status
+	if transaction.Currency == "" && transaction.AccountID == nil {
+		transaction.Currency = oldCurrency
+	}
+	currency, err := h.transactionCurrency(h.DB, userID, transaction.AccountID, transaction.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	transaction.Currency = currency
+	normalizeTransactionDates(&transaction)
+	if transaction.Tags, err = normalizeTags(transaction.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	splitsChanged := transaction.Splits != nil
+	if !splitsChanged {
+		// stored split lines must still add up to the new amount
+		h.DB.Where("transaction_id = ?", transaction.ID).Find(&transaction.Splits)
+	}
+	if err := h.validateSplits(&transaction); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
 		if oldAccountID != nil {
 			tx.Model(&Account{}).Where("id = ? AND user_id = ?", *oldAccountID, userID).
 				UpdateColumn("amount", gorm.Expr("amount - ?", oldAmount))
@@ -484,18 +676,32 @@ func (h *Handler) UpdateTransaction(c *gin.Context) {
 			tx.Model(&Account{}).Where("id = ? AND user_id = ?", *transaction.AccountID, userID).
 				UpdateColumn("amount", gorm.Expr("amount + ?", transaction.Amount))
 		}
-		tx.Save(&transaction)
+		if err := tx.Omit("Splits").Save(&transaction).Error; err != nil {
+			return err
+		}
+		if splitsChanged {
+			return replaceSplits(tx, &transaction)
+		}
 		return nil
 	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.checkBudgetAlerts(userID, append(transactionTouches(&before), transactionTouches(&transaction)...))
 	c.JSON(http.StatusOK, transaction)
 }
 func (h *Handler) DeleteTransaction(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var transaction Transaction
-	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&transaction).Error; err != nil {
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).Preload("Splits").First(&transaction).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
+	if transaction.Status == transactionReconciled {
+		c.JSON(http.StatusConflict, gin.H{"error": errReconciled.Error()})
+		return
+	}
 	h.DB.Transaction(func(tx *gorm.DB) error {
 		if transaction.AccountID != nil {
 			tx.Model(&Account{}).Where("id = ? AND user_id = ?", *transaction.AccountID, userID).
@@ -504,8 +710,33 @@ func (h *Handler) DeleteTransaction(c *gin.Context) {
 		tx.Delete(&transaction)
 		return nil
 	})
+	h.checkBudgetAlerts(userID, transactionTouches(&transaction))
 	c.Status(http.StatusNoContent)
 }
+
+// dateOnly drops the time of day, keeping the calendar date as seen in t's
+// location.
+func dateOnly(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func normalizeTransactionDates(t *Transaction) {
+	t.Date = dateOnly(t.Date)
+	if t.ValueDate != nil {
+		v := dateOnly(*t.ValueDate)
+		t.ValueDate = &v
+	}
+}
+
+// adjustBalance adds delta to the balance of the user's account, if any.
+func adjustBalance(tx *gorm.DB, userID uint, accountID *uint, delta Money) error {
+	if accountID == nil || delta == 0 {
+		return nil
+	}
+	return tx.Model(&Account{}).Where("id = ? AND user_id = ?", *accountID, userID).
+		UpdateColumn("amount", gorm.Expr("amount + ?", delta)).Error
+}
 func (h *Handler) GetAccounts(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var accounts []Account
@@ -515,8 +746,24 @@ func (h *Handler) GetAccounts(c *gin.Context) {
 func (h *Handler) CreateAccount(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var account Account
-	c.ShouldBindJSON(&account)
+	if err := c.ShouldBindJSON(&account); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	account.UserID = userID
+	if account.Currency == "" {
+		account.Currency = h.baseCurrency(userID)
+	}
+	currency, err := normalizeCurrency(account.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	account.Currency = currency
+	if err := account.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	h.DB.Create(&account)
 	c.JSON(http.StatusCreated, account)
 }
@@ -527,8 +774,30 @@ func (h *Handler) UpdateAccount(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
 		return
 	}
-	c.ShouldBindJSON(&account)
+	oldCurrency := account.Currency
+	if err := c.ShouldBindJSON(&account); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	account.UserID = userID
+	currency, err := normalizeCurrency(account.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if currency != oldCurrency {
+		var count int64
+		h.DB.Model(&Transaction{}).Where("account_id = ? AND user_id = ?", account.ID, userID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot change the currency of an account with transactions"})
+			return
+		}
+	}
+	account.Currency = currency
+	if err := account.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	h.DB.Save(&account)
 	c.JSON(http.StatusOK, account)
 }
@@ -544,19 +813,39 @@ func (h *Handler) GetDashboardStats(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	now := time.Now()
 	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-	end := start.AddDate(0, 1, 0).Add(-time.Second)
-	var income, expense float64
+	end := start.AddDate(0, 1, -1)
+	var incomeTotals, expenseTotals []currencyTotal
 	var count int64
-	h.DB.Model(&Transaction{}).Where("user_id = ? AND amount > 0 AND created_at BETWEEN ? AND ?", userID, start, end).
-		Select("COALESCE(SUM(amount), 0)").Scan(&income)
-	h.DB.Model(&Transaction{}).Where("user_id = ? AND amount < 0 AND created_at BETWEEN ? AND ?", userID, start, end).
-		Select("COALESCE(SUM(ABS(amount)), 0)").Scan(&expense)
-	h.DB.Model(&Transaction{}).Where("user_id = ? AND created_at BETWEEN ? AND ?", userID, start, end).Count(&count)
+	h.categoryLines(userID).Where(lineAmount+" > 0 AND t.date BETWEEN ? AND ?", start, end).
+		Select("t.currency AS currency, COALESCE(SUM(" + lineAmount + "), 0) AS total").Group("t.currency").Scan(&incomeTotals)
+	h.categoryLines(userID).Where(lineAmount+" < 0 AND t.date BETWEEN ? AND ?", start, end).
+		Select("t.currency AS currency, COALESCE(SUM(ABS(" + lineAmount + ")), 0) AS total").Group("t.currency").Scan(&expenseTotals)
+	h.DB.Model(&Transaction{}).Where("user_id = ? AND date BETWEEN ? AND ?", userID, start, end).Count(&count)
 	var accounts []Account
 	h.DB.Where("user_id = ?", userID).Find(&accounts)
-	var total float64
+	var accountTotals []currencyTotal
 	for _, a := range accounts {
-		total += a.Amount
+		accountTotals = append(accountTotals, currencyTotal{Currency: a.Currency, Total: a.Amount})
+	}
+	cv := h.newConverter(userID, now)
+	income, _, err := cv.Sum(incomeTotals)
+	var expense, total, assets, liabilities Money
+	if err == nil {
+		expense, _, err = cv.Sum(expenseTotals)
+	}
+	if err == nil {
+		total, _, err = cv.Sum(accountTotals)
+	}
+	if err == nil {
+		assets, liabilities, err = balanceSheet(cv, accounts)
+	}
+	var byCategory []CategorySpending
+	if err == nil {
+		byCategory, err = h.spendingByCategory(userID, start, end, cv)
+	}
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"total_income":          income,
@@ -565,42 +854,72 @@ func (h *Handler) GetDashboardStats(c *gin.Context) {
 		"balance":               income - expense,
 		"accounts":              accounts,
 		"total_account_balance": total,
+		"total_assets":          assets,
+		"total_liabilities":     liabilities,
+		"net_worth":             assets - liabilities,
+		"expenses_by_category":  byCategory,
+		"base_currency":         cv.base,
+		"rates":                 cv.Applied(),
 	})
 }
 func (h *Handler) GetBudgets(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var budgets []Budget
-	h.DB.Where("user_id = ?", userID).Preload("Category").Find(&budgets)
+	h.DB.Where("user_id = ?", userID).Order("id").Find(&budgets)
+	// category_id and account_id list only the budgets spending there counts towards
+	categoryID, accountID, err := budgetFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	type Response struct {
 		Budget
-		Spent      float64 `json:"spent"`
-		Remaining  float64 `json:"remaining"`
-		Percentage float64 `json:"percentage"`
+		PeriodStart time.Time     `json:"period_start"`
+		PeriodEnd   time.Time     `json:"period_end"`
+		RolledOver  Money         `json:"rolled_over"`
+		Available   Money         `json:"available"`
+		Spent       Money         `json:"spent"`
+		Remaining   Money         `json:"remaining"`
+		Percentage  float64       `json:"percentage"`
+		Currency    string        `json:"currency"`
+		Rates       []AppliedRate `json:"rates"`
 	}
-	var result []Response
+	now := time.Now()
+	cv := h.newConverter(userID, now)
+	result := []Response{}
 	for _, b := range budgets {
-		now := time.Now()
-		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-		if b.Criteria == "annual" {
-			start = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+		if (categoryID != nil && len(b.CategoryIDs) > 0 && !b.CategoryIDs.has(*categoryID)) ||
+			(accountID != nil && len(b.AccountIDs) > 0 && !b.AccountIDs.has(*accountID)) {
+			continue
+		}
+		p, err := h.currentBudgetPeriod(cv, userID, &b, now)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
 		}
-		var spent float64
-		h.DB.Model(&Transaction{}).
-			Where("user_id = ? AND category_id = ? AND amount < 0 AND created_at >= ?", userID, b.CategoryID, start).
-			Select("COALESCE(SUM(ABS(amount)), 0)").Scan(&spent)
-		remaining := b.Amount - spent
 		percentage := 0.0
-		if b.Amount > 0 {
-			percentage = (remaining / b.Amount) * 100
+		if p.Available > 0 {
+			percentage = float64(p.Remaining) / float64(p.Available) * 100
 		}
-		result = append(result, Response{b, spent, remaining, percentage})
+		result = append(result, Response{b, p.Start, p.End, p.RolledOver, p.Available, p.Spent, p.Remaining, percentage, cv.base, cv.Applied()})
 	}
 	c.JSON(http.StatusOK, result)
 }
 func (h *Handler) CreateBudget(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var budget Budget
-	c.ShouldBindJSON(&budget)
+	if err := c.ShouldBindJSON(&budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := budget.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.checkBudgetTargets(userID, &budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	budget.UserID = userID
 	h.DB.Create(&budget)
 	c.JSON(http.StatusCreated, budget)
@@ -612,7 +931,18 @@ func (h *Handler) UpdateBudget(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Budget not found"})
 		return
 	}
-	c.ShouldBindJSON(&budget)
+	if err := c.ShouldBindJSON(&budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := budget.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.checkBudgetTargets(userID, &budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	budget.UserID = userID
 	h.DB.Save(&budget)
 	c.JSON(http.StatusOK, budget)
@@ -625,37 +955,96 @@ func (h *Handler) DeleteBudget(c *gin.Context) {
 	}
 	c.Status(http.StatusNoContent)
 }
+
+// CheckBudgetExceeded tells whether spending amount on category_id from
+// account_id on date would overrun any of the budgets it counts towards. The
+// amount is in the currency of the account, as for a new transaction.
 func (h *Handler) CheckBudgetExceeded(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var req struct {
-		CategoryID *uint   `json:"category_id"`
-		Amount     float64 `json:"amount"`
+		CategoryID *uint      `json:"category_id"`
+		AccountID  *uint      `json:"account_id"`
+		Amount     Money      `json:"amount"`
+		Currency   string     `json:"currency"`
+		Date       *time.Time `json:"date"`
 	}
-	c.ShouldBindJSON(&req)
-	if req.CategoryID == nil || req.Amount >= 0 {
-		c.JSON(http.StatusOK, gin.H{"exceeded": false})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var budget Budget
-	if err := h.DB.Where("category_id = ? AND user_id = ?", *req.CategoryID, userID).First(&budget).Error; err != nil {
-		c.JSON(http.StatusOK, gin.H{"exceeded": false})
+	type BudgetCheck struct {
+		BudgetID   uint   `json:"budget_id"`
+		Name       string `json:"name"`
+		Exceeded   bool   `json:"exceeded"`
+		Budget     Money  `json:"budget"`
+		RolledOver Money  `json:"rolled_over"`
+		Available  Money  `json:"available"`
+		Spent      Money  `json:"spent"`
+		NewTotal   Money  `json:"new_total"`
+	}
+	checks := []BudgetCheck{}
+	if req.Amount >= 0 {
+		c.JSON(http.StatusOK, gin.H{"exceeded": false, "budgets": checks})
 		return
 	}
+	var budgets []Budget
+	h.DB.Where("user_id = ?", userID).Order("id").Find(&budgets)
 	now := time.Now()
-	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-	if budget.Criteria == "annual" {
-		start = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
-	}
-	var spent float64
-	h.DB.Model(&Transaction{}).
-		Where("user_id = ? AND category_id = ? AND amount < 0 AND created_at >= ?", userID, *req.CategoryID, start).
-		Select("COALESCE(SUM(ABS(amount)), 0)").Scan(&spent)
-	newTotal := spent + (-req.Amount)
+	if req.Date != nil {
+		now = *req.Date
+	}
+	currency, err := h.transactionCurrency(h.DB, userID, req.AccountID, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Currency = currency
+	cv := h.newConverter(userID, now)
+	var amount Money
+	exceeded := false
+	for i := range budgets {
+		b := &budgets[i]
+		if !b.counts(req.CategoryID, req.AccountID) {
+			continue
+		}
+		period, err := h.currentBudgetPeriod(cv, userID, b, now)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if day := dateOnly(now); day.Before(period.Start) || !day.Before(period.End) {
+			// outside the range of a custom budget
+			continue
+		}
+		if len(checks) == 0 {
+			if amount, err = cv.Convert(req.Amount, req.Currency); err != nil {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		name, err := h.budgetName(userID, b)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		newTotal := period.Spent + (-amount)
+		checks = append(checks, BudgetCheck{
+			BudgetID:   b.ID,
+			Name:       name,
+			Exceeded:   newTotal > period.Available,
+			Budget:     b.Amount,
+			RolledOver: period.RolledOver,
+			Available:  period.Available,
+			Spent:      period.Spent,
+			NewTotal:   newTotal,
+		})
+		exceeded = exceeded || newTotal > period.Available
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"exceeded":  newTotal > budget.Amount,
-		"budget":    budget.Amount,
-		"spent":     spent,
-		"new_total": newTotal,
+		"exceeded": exceeded,
+		"budgets":  checks,
+		"currency": cv.base,
+		"rates":    cv.Applied(),
 	})
 }
 func (h *Handler) GetScheduledTransactions(c *gin.Context) {
@@ -667,8 +1056,15 @@ func (h *Handler) GetScheduledTransactions(c *gin.Context) {
 func (h *Handler) CreateScheduledTransaction(c *gin.Context) {
 	userID := c.GetUint("user_id")
 	var st ScheduledTransaction
-	c.ShouldBindJSON(&st)
+	if err := c.ShouldBindJSON(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	st.UserID = userID
+	if err := normalizeScheduled(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	h.DB.Create(&st)
 	c.JSON(http.StatusCreated, st)
 }
@@ -679,8 +1075,25 @@ func (h *Handler) UpdateScheduledTransaction(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
 		return
 	}
-	c.ShouldBindJSON(&st)
+	oldRule, oldRepetition := st.RRule, st.Repetition
+	oldStart, oldRepeatAt := st.StartsAt, st.RepeatAt
+	if err := c.ShouldBindJSON(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	st.UserID = userID
+	if st.RRule == oldRule && st.Repetition != oldRepetition {
+		// switched from a custom rule back to a shorthand
+		st.RRule = ""
+	}
+	if st.StartsAt.Equal(oldStart) && !st.RepeatAt.Equal(oldRepeatAt) {
+		// moving the next due date moves the whole schedule
+		st.StartsAt = st.RepeatAt
+	}
+	if err := normalizeScheduled(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	h.DB.Save(&st)
 	c.JSON(http.StatusOK, st)
 }
@@ -694,37 +1107,10 @@ func (h *Handler) DeleteScheduledTransaction(c *gin.Context) {
 }
 func (h *Handler) ProcessScheduledTransactions(c *gin.Context) {
 	userID := c.GetUint("user_id")
-	var scheduled []ScheduledTransaction
-	h.DB.Where("user_id = ? AND repeat_at <= ?", userID, time.Now()).Find(&scheduled)
-	processed := 0
-	for _, st := range scheduled {
-		h.DB.Transaction(func(tx *gorm.DB) error {
-			tx.Create(&Transaction{
-				Name:       st.Name,
-				Amount:     st.Amount,
-				UserID:     userID,
-				CategoryID: st.CategoryID,
-				AccountID:  st.AccountID,
-			})
-			if st.AccountID != nil {
-				tx.Model(&Account{}).Where("id = ? AND user_id = ?", *st.AccountID, userID).
-					UpdateColumn("amount", gorm.Expr("amount + ?", st.Amount))
-			}
-			var nextRepeat time.Time
-			switch st.Repetition {
-			case "monthly":
-				nextRepeat = st.RepeatAt.AddDate(0, 1, 0)
-			case "3 months":
-				nextRepeat = st.RepeatAt.AddDate(0, 3, 0)
-			case "6 months":
-				nextRepeat = st.RepeatAt.AddDate(0, 6, 0)
-			case "annually":
-				nextRepeat = st.RepeatAt.AddDate(1, 0, 0)
-			}
-			tx.Model(&st).Update("repeat_at", nextRepeat)
-			processed++
-			return nil
-		})
+	processed, err := h.processDueScheduled(c.Request.Context(), userID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"processed": processed})
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount stored as a whole number of cents. It maps onto the
+// DECIMAL(12,2) columns and is written to JSON as a plain number with two
+// decimals, so clients keep receiving numbers.
+//
+// Rounding rules: amounts coming from clients must already be whole cents and
+// anything finer is rejected. Values produced by the database or by Mul
+// (averages, converted totals) are rounded half away from zero to the cent.
+type Money int64
+
+var (
+	errMoneySubCent  = errors.New("amount has more than two decimal places")
+	errMoneyInvalid  = errors.New("invalid amount")
+	errMoneyOverflow = errors.New("amount out of range")
+)
+
+// maxMoney is the largest value a DECIMAL(12,2) column can hold.
+const maxMoney Money = 999999999999
+
+var moneyPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d{1,3})?$`)
+
+// ParseMoney parses a decimal string such as "-12.5" or "1000.00". It rejects
+// more than two non-zero decimal places instead of rounding them away.
+func ParseMoney(s string) (Money, error) {
+	return parseMoney(s, false)
+}
+
+func parseMoney(s string, round bool) (Money, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 40 || !moneyPattern.MatchString(s) {
+		return 0, errMoneyInvalid
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, errMoneyInvalid
+	}
+	r.Mul(r, big.NewRat(100, 1))
+	if round {
+		return moneyFromRat(r)
+	}
+	if !r.IsInt() {
+		return 0, errMoneySubCent
+	}
+	m, err := moneyFromRat(r)
+	if err == nil && m.Abs() > maxMoney {
+		err = errMoneyOverflow
+	}
+	return m, err
+}
+
+// moneyFromRat rounds a value expressed in cents half away from zero.
+func moneyFromRat(r *big.Rat) (Money, error) {
+	num, den := new(big.Int).Set(r.Num()), r.Denom()
+	neg := num.Sign() < 0
+	num.Abs(num)
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if neg {
+		q.Neg(q)
+	}
+	if !q.IsInt64() {
+		return 0, errMoneyOverflow
+	}
+	return Money(q.Int64()), nil
+}
+
+// MoneyFromFloat converts a float rounding half away from zero. Only use it
+// for values that were never money to begin with, such as ratios.
+func MoneyFromFloat(f float64) Money {
+	return Money(math.Round(f * 100))
+}
+
+// Float64 returns the amount in currency units for percentages and display.
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Abs returns the absolute value of m.
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Mul multiplies m by a decimal factor, e.g. an exchange rate, rounding the
+// result to the cent.
+func (m Money) Mul(factor *big.Rat) Money {
+	r := new(big.Rat).Mul(big.NewRat(int64(m), 1), factor)
+	v, err := moneyFromRat(r)
+	if err != nil {
+		if r.Sign() < 0 {
+			return math.MinInt64 + 1
+		}
+		return math.MaxInt64
+	}
+	return v
+}
+
+// String formats m with exactly two decimals, e.g. "-12.30".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a quoted decimal string.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, string(data))
+	}
+	*m = v
+	return nil
+}
+
+// Value writes the amount as a decimal string so Postgres stores it exactly.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads NUMERIC results, including aggregates such as SUM and AVG that
+// may carry more than two decimals or exceed the column range.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case int64:
+		*m = Money(v * 100)
+		return nil
+	case float64:
+		*m = MoneyFromFloat(v)
+		return nil
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	}
+	return fmt.Errorf("cannot scan %T into Money", src)
+}
+
+func (m *Money) scanString(s string) error {
+	v, err := parseMoney(s, true)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
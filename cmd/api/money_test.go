@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{"0", 0, nil},
+		{"12", 1200, nil},
+		{"-12.5", -1250, nil},
+		{"+1000.00", 100000, nil},
+		{" 3.07 ", 307, nil},
+		{".5", 50, nil},
+		{"7.", 700, nil},
+		{"1.230", 123, nil},
+		{"1.5e2", 15000, nil},
+		{"9999999999.99", 999999999999, nil},
+		{"1.234", 0, errMoneySubCent},
+		{"0.001", 0, errMoneySubCent},
+		{"10000000000", 0, errMoneyOverflow},
+		{"", 0, errMoneyInvalid},
+		{"abc", 0, errMoneyInvalid},
+		{"1,50", 0, errMoneyInvalid},
+		{"1/2", 0, errMoneyInvalid},
+		{"NaN", 0, errMoneyInvalid},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseMoney(%q) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("ParseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want Money
+	}{
+		{nil, 0},
+		{int64(42), 4200},
+		{float64(0.125), 13},
+		{"12.30", 1230},
+		{[]byte("-7.25"), -725},
+		// aggregates are rounded half away from zero
+		{"3.3333333333", 333},
+		{"0.005", 1},
+		{"-0.005", -1},
+		{"2.675", 268},
+		{"-2.674", -267},
+		// and may exceed the column range
+		{"123456789012.34", 12345678901234},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := m.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%#v) error = %v", tt.src, err)
+			continue
+		}
+		if m != tt.want {
+			t.Errorf("Scan(%#v) = %d, want %d", tt.src, m, tt.want)
+		}
+	}
+	var m Money
+	if err := m.Scan(true); err == nil {
+		t.Errorf("Scan(true) error = nil, want an error")
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{1230, "12.30"},
+		{-100000, "-1000.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMul(t *testing.T) {
+	tests := []struct {
+		m      Money
+		factor string
+		want   Money
+	}{
+		{1000, "1.5", 1500},
+		{1000, "0.0001", 0},
+		{1000, "0.0005", 1},
+		{-1000, "0.0005", -1},
+		{333, "0.5", 167},
+		{10000, "15750.25", 157502500},
+	}
+	for _, tt := range tests {
+		factor, _ := new(big.Rat).SetString(tt.factor)
+		if got := tt.m.Mul(factor); got != tt.want {
+			t.Errorf("Money(%d).Mul(%s) = %d, want %d", tt.m, tt.factor, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{`12.5`, 1250, false},
+		{`"12.50"`, 1250, false},
+		{`null`, 0, false},
+		{`12.345`, 0, true},
+		{`"x"`, 0, true},
+	}
+	for _, tt := range tests {
+		var m Money
+		err := m.UnmarshalJSON([]byte(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("UnmarshalJSON(%s) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if m != tt.want {
+			t.Errorf("UnmarshalJSON(%s) = %d, want %d", tt.in, m, tt.want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Transaction statuses. A transaction is cleared once it shows on a bank
+// statement and reconciled once a reconciliation has matched it; reconciled
+// transactions are locked until explicitly unlocked.
+const (
+	transactionUncleared  = "uncleared"
+	transactionCleared    = "cleared"
+	transactionReconciled = "reconciled"
+)
+
+// errReconciled answers edits to a locked transaction.
+var errReconciled = errors.New("the transaction is reconciled; unlock it before changing it")
+
+// errTransferReconciled answers edits to a transfer dated on or before a
+// reconciled statement of one of its accounts.
+var errTransferReconciled = errors.New("the transfer falls within a reconciled statement and cannot be changed")
+
+// normalizeStatus defaults an empty status to uncleared and rejects the
+// reconciled status, which only a reconciliation gives.
+func normalizeStatus(status string) (string, error) {
+	switch status {
+	case "":
+		return transactionUncleared, nil
+	case transactionUncleared, transactionCleared:
+		return status, nil
+	case transactionReconciled:
+		return "", errors.New("transactions are reconciled by reconciling their account")
+	}
+	return "", errors.New("status must be uncleared or cleared")
+}
+
+// Reconciliation records that the cleared balance of an account matched a
+// bank statement ending on StatementDate.
+type Reconciliation struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	AccountID        uint      `gorm:"not null;index" json:"account_id"`
+	StatementDate    time.Time `gorm:"type:date;not null" json:"statement_date"`
+	StatementBalance Money     `gorm:"type:decimal(12,2);not null" json:"statement_balance"`
+	// TransactionCount is how many transactions the reconciliation locked.
+	TransactionCount int       `gorm:"not null" json:"transaction_count"`
+	UserID           uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ReconciliationStatus compares an account with a statement.
+type ReconciliationStatus struct {
+	AccountID        uint      `json:"account_id"`
+	StatementDate    time.Time `json:"statement_date"`
+	StatementBalance Money     `json:"statement_balance"`
+	ClearedBalance   Money     `json:"cleared_balance"`
+	Difference       Money     `json:"difference"`
+	ClearedCount     int64     `json:"cleared_count"`
+	UnclearedCount   int64     `json:"uncleared_count"`
+	Currency         string    `json:"currency"`
+}
+
+// reconciliationStatus works out the cleared balance of account on
+// statementDate: its balance less the transactions not cleared or reconciled
+// by then and the transactions and transfers dated after it. Transfers carry
+// no status, so those dated up to the statement count as cleared.
+func reconciliationStatus(db *gorm.DB, account *Account, statementDate time.Time, statementBalance Money) (ReconciliationStatus, error) {
+	s := ReconciliationStatus{
+		AccountID:        account.ID,
+		StatementDate:    statementDate,
+		StatementBalance: statementBalance,
+		Currency:         account.Currency,
+	}
+	var pending struct{ Total Money }
+	err := db.Model(&Transaction{}).
+		Where("account_id = ? AND user_id = ? AND (status = ? OR date > ?)", account.ID, account.UserID, transactionUncleared, statementDate).
+		Select("COALESCE(SUM(amount), 0) AS total").Scan(&pending).Error
+	if err != nil {
+		return s, err
+	}
+	// what later transfers added to the account: to_amount coming in,
+	// amount and fee going out
+	var transfers struct{ Total Money }
+	err = db.Model(&Transfer{}).
+		Where("user_id = ? AND (from_account_id = ? OR to_account_id = ?) AND date > ?", account.UserID, account.ID, account.ID, statementDate).
+		Select("COALESCE(SUM(CASE WHEN to_account_id = ? THEN to_amount ELSE 0 END) - SUM(CASE WHEN from_account_id = ? THEN amount + fee ELSE 0 END), 0) AS total", account.ID, account.ID).
+		Scan(&transfers).Error
+	if err != nil {
+		return s, err
+	}
+	s.ClearedBalance = account.Amount - pending.Total - transfers.Total
+	s.Difference = statementBalance - s.ClearedBalance
+	base := db.Model(&Transaction{}).Where("account_id = ? AND user_id = ? AND date <= ?", account.ID, account.UserID, statementDate).Session(&gorm.Session{})
+	if err := base.Where("status = ?", transactionCleared).Count(&s.ClearedCount).Error; err != nil {
+		return s, err
+	}
+	if err := base.Where("status = ?", transactionUncleared).Count(&s.UnclearedCount).Error; err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// transferReconciled tells whether t is dated on or before the latest
+// reconciliation of either of its accounts. Transfers have no status of their
+// own, so they are locked by date instead.
+func transferReconciled(db *gorm.DB, t *Transfer) (bool, error) {
+	var accountIDs []uint
+	for _, id := range []*uint{t.FromAccountID, t.ToAccountID} {
+		if id != nil {
+			accountIDs = append(accountIDs, *id)
+		}
+	}
+	if len(accountIDs) == 0 {
+		return false, nil
+	}
+	var count int64
+	err := db.Model(&Reconciliation{}).
+		Where("user_id = ? AND account_id IN ? AND statement_date >= ?", t.UserID, accountIDs, t.Date).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// parseStatementDate reads the day a statement ends.
+func parseStatementDate(date string) (time.Time, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return time.Time{}, errors.New("statement_date must be a date (YYYY-MM-DD)")
+	}
+	return day, nil
+}
+
+// GetReconciliationStatus compares the account with a statement given by
+// statement_date and statement_balance, without changing anything.
+func (h *Handler) GetReconciliationStatus(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var account Account
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&account).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+	day, err := parseStatementDate(c.Query("statement_date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	balance, err := ParseMoney(c.Query("statement_balance"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("statement_balance: %v", err)})
+		return
+	}
+	status, err := reconciliationStatus(h.DB, &account, day, balance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// ReconcileAccount finishes a reconciliation: when the cleared balance
+// matches the statement, the cleared transactions up to the statement date
+// become reconciled and the reconciliation is recorded. Otherwise nothing
+// changes and the difference is reported.
+func (h *Handler) ReconcileAccount(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		StatementDate    string `json:"statement_date" binding:"required"`
+		StatementBalance *Money `json:"statement_balance" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	day, err := parseStatementDate(req.StatementDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	balance := *req.StatementBalance
+	var status ReconciliationStatus
+	var reconciliation Reconciliation
+	errNotFound := errors.New("Account not found")
+	errDifference := errors.New("the cleared balance does not match the statement")
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var account Account
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&account).Error
+		if err != nil {
+			return errNotFound
+		}
+		if status, err = reconciliationStatus(tx, &account, day, balance); err != nil {
+			return err
+		}
+		if status.Difference != 0 {
+			return errDifference
+		}
+		result := tx.Model(&Transaction{}).
+			Where("account_id = ? AND user_id = ? AND status = ? AND date <= ?", account.ID, userID, transactionCleared, day).
+			Update("status", transactionReconciled)
+		if result.Error != nil {
+			return result.Error
+		}
+		reconciliation = Reconciliation{
+			AccountID:        account.ID,
+			StatementDate:    day,
+			StatementBalance: balance,
+			TransactionCount: int(result.RowsAffected),
+			UserID:           userID,
+		}
+		return tx.Create(&reconciliation).Error
+	})
+	switch {
+	case errors.Is(err, errNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errDifference):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": status})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusCreated, reconciliation)
+	}
+}
+
+// GetReconciliations lists the reconciliations of an account, latest first.
+func (h *Handler) GetReconciliations(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var reconciliations []Reconciliation
+	h.DB.Where("account_id = ? AND user_id = ?", c.Param("id"), userID).
+		Order("statement_date DESC, id DESC").Find(&reconciliations)
+	c.JSON(http.StatusOK, reconciliations)
+}
+
+// SetTransactionStatus marks a transaction cleared or uncleared.
+func (h *Handler) SetTransactionStatus(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		Status string `json:"status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status, err := normalizeStatus(req.Status)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var transaction Transaction
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&transaction).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if transaction.Status == transactionReconciled {
+		c.JSON(http.StatusConflict, gin.H{"error": errReconciled.Error()})
+		return
+	}
+	if err := h.DB.Model(&transaction).Update("status", status).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	transaction.Status = status
+	c.JSON(http.StatusOK, transaction)
+}
+
+// UnlockTransaction turns a reconciled transaction back into a cleared one
+// so it can be changed again.
+func (h *Handler) UnlockTransaction(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var transaction Transaction
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&transaction).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if transaction.Status != transactionReconciled {
+		c.JSON(http.StatusConflict, gin.H{"error": "the transaction is not reconciled"})
+		return
+	}
+	if err := h.DB.Model(&transaction).Update("status", transactionCleared).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	transaction.Status = transactionCleared
+	c.JSON(http.StatusOK, transaction)
+}
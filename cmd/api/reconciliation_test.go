@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeStatus(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"", transactionUncleared, false},
+		{"uncleared", transactionUncleared, false},
+		{"cleared", transactionCleared, false},
+		{"reconciled", "", true},
+		{"pending", "", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeStatus(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizeStatus(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReconciliationStatus(t *testing.T) {
+	db := testDB(t)
+	user := testUser(t, db)
+	account := testAccount(t, db, user.ID, accountChecking, 100000)
+	other := testAccount(t, db, user.ID, accountSavings, 0)
+	for _, tr := range []Transaction{
+		{Name: "reconciled", Amount: 5000, Date: mustDate(t, "2024-03-01"), Status: transactionReconciled},
+		{Name: "cleared", Amount: -2000, Date: mustDate(t, "2024-03-05"), Status: transactionCleared},
+		{Name: "uncleared", Amount: -3000, Date: mustDate(t, "2024-03-06"), Status: transactionUncleared},
+		{Name: "cleared later", Amount: -4000, Date: mustDate(t, "2024-03-20"), Status: transactionCleared},
+	} {
+		tr.UserID, tr.AccountID, tr.Currency = user.ID, &account.ID, "IDR"
+		if err := db.Create(&tr).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tr := range []Transfer{
+		{FromAccountID: &other.ID, ToAccountID: &account.ID, Amount: 10000, ToAmount: 10000, Date: mustDate(t, "2024-03-10")},
+		{FromAccountID: &account.ID, ToAccountID: &other.ID, Amount: 1000, ToAmount: 1000, Fee: 100, Date: mustDate(t, "2024-03-20")},
+		{FromAccountID: &other.ID, ToAccountID: &account.ID, Amount: 7000, ToAmount: 7000, Date: mustDate(t, "2024-03-25")},
+	} {
+		tr.UserID = user.ID
+		if err := db.Create(&tr).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := reconciliationStatus(db, &account, mustDate(t, "2024-03-15"), 101000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000.00 less the uncleared -30.00 and the later -40.00, less the later
+	// transfers: -11.00 out and +70.00 in
+	if s.ClearedBalance != 101100 || s.Difference != -100 {
+		t.Errorf("cleared balance = %s, difference %s; want 1011.00, -1.00", s.ClearedBalance, s.Difference)
+	}
+	if s.ClearedCount != 1 || s.UnclearedCount != 1 {
+		t.Errorf("counts = %d cleared, %d uncleared; want 1 and 1", s.ClearedCount, s.UnclearedCount)
+	}
+}
+
+func TestTransferReconciledLock(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	account := testAccount(t, db, user.ID, accountChecking, 100000)
+	other := testAccount(t, db, user.ID, accountSavings, 0)
+	reconciliation := Reconciliation{AccountID: account.ID, StatementDate: mustDate(t, "2024-03-15"), UserID: user.ID}
+	if err := db.Create(&reconciliation).Error; err != nil {
+		t.Fatal(err)
+	}
+	transfer := func(date string) Transfer {
+		tr := Transfer{FromAccountID: &account.ID, ToAccountID: &other.ID, Amount: 1000, ToAmount: 1000, Date: mustDate(t, date), UserID: user.ID}
+		if err := db.Create(&tr).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := tr.apply(db, 1); err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+	id := func(tr Transfer) gin.Params { return gin.Params{{Key: "id", Value: fmt.Sprint(tr.ID)}} }
+	update := func(date string) gin.H {
+		return gin.H{"from_account_id": account.ID, "to_account_id": other.ID, "amount": 20, "date": mustDate(t, date)}
+	}
+
+	reconciled, later := transfer("2024-03-15"), transfer("2024-03-20")
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		params  gin.Params
+		body    gin.H
+		want    int
+	}{
+		{"update on the statement date", h.UpdateTransfer, id(reconciled), update("2024-03-25"), http.StatusConflict},
+		{"delete on the statement date", h.DeleteTransfer, id(reconciled), nil, http.StatusConflict},
+		{"move into the statement", h.UpdateTransfer, id(later), update("2024-03-01"), http.StatusConflict},
+		{"update after the statement", h.UpdateTransfer, id(later), update("2024-03-25"), http.StatusOK},
+		{"delete after the statement", h.DeleteTransfer, id(later), nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		w := serve(tt.handler, user.ID, "/", tt.params, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+	// only the reconciled transfer is still booked
+	db.First(&account, account.ID)
+	if account.Amount != 99000 {
+		t.Errorf("balance = %s, want 990.00", account.Amount)
+	}
+}
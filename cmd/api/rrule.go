@@ -0,0 +1,528 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence rules follow RFC 5545 section 3.3.10 for the parts that make
+// sense for dated transactions: FREQ (DAILY, WEEKLY, MONTHLY, YEARLY),
+// INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH, BYSETPOS and WKST.
+// Occurrences are whole days that keep the time of day of the start. Unlike
+// RFC 5545, the start is only an occurrence when it matches the rule, so
+// "every 15th and last day" started on the 3rd first occurs on the 15th.
+
+type rruleFreq int
+
+const (
+	freqDaily rruleFreq = iota
+	freqWeekly
+	freqMonthly
+	freqYearly
+)
+
+var rruleFreqs = map[string]rruleFreq{
+	"DAILY":   freqDaily,
+	"WEEKLY":  freqWeekly,
+	"MONTHLY": freqMonthly,
+	"YEARLY":  freqYearly,
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// rruleMaxEmptyYears stops rules that can never match, such as
+// FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30, once that many years have gone by
+// without an occurrence. Counting years rather than periods keeps daily
+// rules with rare matches, such as FREQ=DAILY;BYMONTH=2;BYMONTHDAY=29, from
+// stopping early; the calendar repeats every 400 years.
+const rruleMaxEmptyYears = 400
+
+// weekdayNum is a BYDAY entry: a weekday, optionally the Nth of the month or
+// year (negative N counts from the end).
+type weekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+type RRule struct {
+	Freq       rruleFreq
+	Interval   int
+	Count      int
+	Until      time.Time
+	untilDate  bool
+	ByDay      []weekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+}
+
+// ParseRRule parses the value of an RRULE property, with or without the
+// "RRULE:" prefix.
+func ParseRRule(s string) (*RRule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	r := &RRule{Freq: -1, Interval: 1, WeekStart: time.Monday}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s given twice", name)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "FREQ":
+			freq, ok := rruleFreqs[value]
+			if !ok {
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+			r.Freq = freq
+		case "INTERVAL":
+			r.Interval, err = rruleInt(name, value, 1, 1000)
+		case "COUNT":
+			r.Count, err = rruleInt(name, value, 1, 100000)
+		case "UNTIL":
+			err = r.parseUntil(value)
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(v)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = rruleInts(name, value, 31)
+		case "BYMONTH":
+			r.ByMonth, err = rruleInts(name, value, 12)
+			for _, m := range r.ByMonth {
+				if m < 0 {
+					return nil, errors.New("BYMONTH must be between 1 and 12")
+				}
+			}
+		case "BYSETPOS":
+			r.BySetPos, err = rruleInts(name, value, 366)
+		case "WKST":
+			day, ok := rruleWeekdays[value]
+			if !ok {
+				return nil, fmt.Errorf("invalid WKST %q", value)
+			}
+			r.WeekStart = day
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Freq < 0 {
+		return nil, errors.New("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, errors.New("COUNT and UNTIL cannot be combined")
+	}
+	if r.Freq == freqWeekly && len(r.ByMonthDay) > 0 {
+		return nil, errors.New("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N != 0 && r.Freq != freqMonthly && r.Freq != freqYearly {
+			return nil, errors.New("numbered BYDAY values need FREQ=MONTHLY or FREQ=YEARLY")
+		}
+	}
+	return r, nil
+}
+
+func rruleInt(name, value string, min, max int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+	}
+	return n, nil
+}
+
+// rruleInts parses a list of non-zero integers within ±max.
+func rruleInts(name, value string, max int) ([]int, error) {
+	var list []int
+	for _, v := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil || n == 0 || n < -max || n > max {
+			return nil, fmt.Errorf("invalid %s value %q", name, v)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func parseWeekdayNum(s string) (weekdayNum, error) {
+	if len(s) < 2 {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY value %q", s)
+	}
+	day, ok := rruleWeekdays[s[len(s)-2:]]
+	if !ok {
+		return weekdayNum{}, fmt.Errorf("invalid BYDAY value %q", s)
+	}
+	wd := weekdayNum{Day: day}
+	if prefix := s[:len(s)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return weekdayNum{}, fmt.Errorf("invalid BYDAY value %q", s)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+func (r *RRule) parseUntil(value string) error {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			r.Until = t
+			r.untilDate = layout == "20060102"
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid UNTIL %q", value)
+}
+
+// civil is a calendar date, as UTC midnight.
+func civil(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// periodStart returns the first day of the period holding date.
+func (r *RRule) periodStart(date time.Time) time.Time {
+	switch r.Freq {
+	case freqWeekly:
+		return date.AddDate(0, 0, -((int(date.Weekday()) - int(r.WeekStart) + 7) % 7))
+	case freqMonthly:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case freqYearly:
+		return time.Date(date.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return date
+}
+
+func (r *RRule) nextPeriod(period time.Time) time.Time {
+	switch r.Freq {
+	case freqWeekly:
+		return period.AddDate(0, 0, 7*r.Interval)
+	case freqMonthly:
+		return period.AddDate(0, r.Interval, 0)
+	case freqYearly:
+		return period.AddDate(r.Interval, 0, 0)
+	}
+	return period.AddDate(0, 0, r.Interval)
+}
+
+// matchesMonthDay reports whether date is one of the BYMONTHDAY days.
+func (r *RRule) matchesMonthDay(date time.Time) bool {
+	last := daysIn(date.Year(), date.Month())
+	for _, md := range r.ByMonthDay {
+		if md == date.Day() || md < 0 && last+md+1 == date.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDay reports whether date is one of the BYDAY days; numbered entries
+// count within the month, or within the year when inYear is set.
+func (r *RRule) matchesDay(date time.Time, inYear bool) bool {
+	for _, wd := range r.ByDay {
+		if date.Weekday() != wd.Day {
+			continue
+		}
+		if wd.N == 0 {
+			return true
+		}
+		pos, total := date.Day(), daysIn(date.Year(), date.Month())
+		if inYear {
+			pos = date.YearDay()
+			total = time.Date(date.Year(), 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		}
+		if wd.N > 0 && (pos-1)/7+1 == wd.N || wd.N < 0 && (total-pos)/7+1 == -wd.N {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDates returns the dates of one month selected by BYMONTHDAY and
+// BYDAY, or the start's day of month when neither is given.
+func (r *RRule) monthDates(year int, month time.Month, start time.Time, inYear bool) []time.Time {
+	last := daysIn(year, month)
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		if start.Day() > last {
+			return nil
+		}
+		return []time.Time{time.Date(year, month, start.Day(), 0, 0, 0, 0, time.UTC)}
+	}
+	var dates []time.Time
+	for d := 1; d <= last; d++ {
+		date := time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+		if len(r.ByMonthDay) > 0 && !r.matchesMonthDay(date) {
+			continue
+		}
+		if len(r.ByDay) > 0 && !r.matchesDay(date, inYear) {
+			continue
+		}
+		dates = append(dates, date)
+	}
+	return dates
+}
+
+// candidates returns the dates of the period starting on period, in order.
+func (r *RRule) candidates(period, start time.Time) []time.Time {
+	var dates []time.Time
+	switch r.Freq {
+	case freqDaily:
+		if (len(r.ByMonth) == 0 || containsInt(r.ByMonth, int(period.Month()))) &&
+			(len(r.ByMonthDay) == 0 || r.matchesMonthDay(period)) &&
+			(len(r.ByDay) == 0 || r.matchesDay(period, false)) {
+			dates = append(dates, period)
+		}
+	case freqWeekly:
+		for i := 0; i < 7; i++ {
+			date := period.AddDate(0, 0, i)
+			if len(r.ByDay) > 0 && !r.matchesDay(date, false) || len(r.ByDay) == 0 && date.Weekday() != start.Weekday() {
+				continue
+			}
+			if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(date.Month())) {
+				continue
+			}
+			dates = append(dates, date)
+		}
+	case freqMonthly:
+		if len(r.ByMonth) == 0 || containsInt(r.ByMonth, int(period.Month())) {
+			dates = r.monthDates(period.Year(), period.Month(), start, false)
+		}
+	case freqYearly:
+		switch {
+		case len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) > 0:
+			for date := period; date.Year() == period.Year(); date = date.AddDate(0, 0, 1) {
+				if r.matchesDay(date, true) {
+					dates = append(dates, date)
+				}
+			}
+		default:
+			months := r.ByMonth
+			if len(months) == 0 {
+				months = []int{int(start.Month())}
+				if len(r.ByMonthDay) > 0 {
+					months = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+				}
+			}
+			sorted := append([]int(nil), months...)
+			sort.Ints(sorted)
+			for _, m := range sorted {
+				dates = append(dates, r.monthDates(period.Year(), time.Month(m), start, false)...)
+			}
+		}
+	}
+	if len(r.BySetPos) == 0 || len(dates) == 0 {
+		return dates
+	}
+	var picked []time.Time
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(dates) + pos
+		}
+		if i >= 0 && i < len(dates) {
+			picked = append(picked, dates[i])
+		}
+	}
+	sort.Slice(picked, func(i, j int) bool { return picked[i].Before(picked[j]) })
+	unique := picked[:0]
+	for i, d := range picked {
+		if i == 0 || !d.Equal(picked[i-1]) {
+			unique = append(unique, d)
+		}
+	}
+	return unique
+}
+
+// Recurrence is a rule anchored at a start, minus the excluded dates.
+type Recurrence struct {
+	Rule    *RRule
+	Start   time.Time
+	exclude map[time.Time]bool
+}
+
+func NewRecurrence(rule *RRule, start time.Time, exDates []time.Time) *Recurrence {
+	exclude := map[time.Time]bool{}
+	for _, d := range exDates {
+		exclude[civil(d)] = true
+	}
+	return &Recurrence{Rule: rule, Start: start, exclude: exclude}
+}
+
+// at gives a date the time of day of the start.
+func (rc *Recurrence) at(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(),
+		rc.Start.Hour(), rc.Start.Minute(), rc.Start.Second(), rc.Start.Nanosecond(), rc.Start.Location())
+}
+
+// each calls fn with every occurrence in order until fn returns false or the
+// rule ends. Excluded dates still count towards COUNT, as in RFC 5545.
+func (rc *Recurrence) each(fn func(time.Time) bool) {
+	r := rc.Rule
+	start := civil(rc.Start)
+	count := 0
+	matched := r.periodStart(start)
+	for period := matched; period.Before(matched.AddDate(rruleMaxEmptyYears, 0, 0)); period = r.nextPeriod(period) {
+		for _, date := range r.candidates(period, start) {
+			if date.Before(start) {
+				continue
+			}
+			matched = period
+			occurrence := rc.at(date)
+			if !r.Until.IsZero() && (r.untilDate && date.After(r.Until) || !r.untilDate && occurrence.After(r.Until)) {
+				return
+			}
+			count++
+			if !rc.exclude[date] && !fn(occurrence) {
+				return
+			}
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// Between returns up to limit occurrences from from to to, both inclusive.
+func (rc *Recurrence) Between(from, to time.Time, limit int) []time.Time {
+	var occurrences []time.Time
+	rc.each(func(t time.Time) bool {
+		if t.After(to) || len(occurrences) >= limit {
+			return false
+		}
+		if !t.Before(from) {
+			occurrences = append(occurrences, t)
+		}
+		return len(occurrences) < limit
+	})
+	return occurrences
+}
+
+// After returns the first occurrence strictly after t.
+func (rc *Recurrence) After(t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	rc.each(func(o time.Time) bool {
+		if o.After(t) {
+			next, found = o, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// DateList is a list of calendar dates, written as "YYYY-MM-DD" strings in
+// JSON and stored as a comma separated list.
+type DateList []time.Time
+
+func parseListDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return civil(t), nil
+}
+
+func (l DateList) MarshalJSON() ([]byte, error) {
+	dates := make([]string, len(l))
+	for i, d := range l {
+		dates[i] = d.Format("2006-01-02")
+	}
+	return json.Marshal(dates)
+}
+
+func (l *DateList) UnmarshalJSON(data []byte) error {
+	var dates []string
+	if err := json.Unmarshal(data, &dates); err != nil {
+		return errors.New("dates must be a list of YYYY-MM-DD strings")
+	}
+	list := make(DateList, 0, len(dates))
+	for _, s := range dates {
+		d, err := parseListDate(s)
+		if err != nil {
+			return err
+		}
+		list = append(list, d)
+	}
+	*l = list
+	return nil
+}
+
+func (l DateList) Value() (driver.Value, error) {
+	dates := make([]string, len(l))
+	for i, d := range l {
+		dates[i] = d.Format("2006-01-02")
+	}
+	return strings.Join(dates, ","), nil
+}
+
+func (l *DateList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into DateList", src)
+	}
+	list := DateList{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		d, err := parseListDate(part)
+		if err != nil {
+			return err
+		}
+		list = append(list, d)
+	}
+	*l = list
+	return nil
+}
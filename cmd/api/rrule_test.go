@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRRuleErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240101",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=DAILY;BYSECOND=1",
+		"FREQ=DAILY;WKST=XX",
+	}
+	for _, s := range tests {
+		if _, err := ParseRRule(s); err == nil {
+			t.Errorf("ParseRRule(%q) error = nil, want an error", s)
+		}
+	}
+}
+
+func TestRecurrenceBetween(t *testing.T) {
+	tests := []struct {
+		rule    string
+		start   string
+		exDates []string
+		want    []string
+	}{
+		{"FREQ=DAILY;COUNT=3", "2024-01-30", nil, []string{"2024-01-30", "2024-01-31", "2024-02-01"}},
+		{"RRULE:FREQ=DAILY;UNTIL=20240103", "2024-01-01", nil, []string{"2024-01-01", "2024-01-02", "2024-01-03"}},
+		{"FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4", "2024-01-03", nil, []string{"2024-01-03", "2024-01-08", "2024-01-10", "2024-01-15"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=FR;COUNT=3", "2024-01-05", nil, []string{"2024-01-05", "2024-01-19", "2024-02-02"}},
+		// months without the day are skipped
+		{"FREQ=MONTHLY;BYMONTHDAY=31;COUNT=4", "2024-01-31", nil, []string{"2024-01-31", "2024-03-31", "2024-05-31", "2024-07-31"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=3", "2024-01-15", nil, []string{"2024-01-31", "2024-02-29", "2024-03-31"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=3", "2024-01-01", nil, []string{"2024-01-26", "2024-02-23", "2024-03-29"}},
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=3", "2024-01-01", nil, []string{"2024-01-31", "2024-02-29", "2024-03-29"}},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29;COUNT=3", "2024-01-01", nil, []string{"2024-02-29", "2028-02-29", "2032-02-29"}},
+		// 2100 is no leap year, so eight years go by without an occurrence
+		{"FREQ=DAILY;BYMONTH=2;BYMONTHDAY=29;COUNT=2", "2097-03-01", nil, []string{"2104-02-29", "2108-02-29"}},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", "2024-01-01", nil, nil},
+		// excluded dates count towards COUNT
+		{"FREQ=DAILY;COUNT=3", "2024-01-01", []string{"2024-01-02"}, []string{"2024-01-01", "2024-01-03"}},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRRule(tt.rule)
+		if err != nil {
+			t.Errorf("ParseRRule(%q) error = %v", tt.rule, err)
+			continue
+		}
+		start := mustDate(t, tt.start).Add(9*time.Hour + 30*time.Minute)
+		var exDates []time.Time
+		for _, d := range tt.exDates {
+			exDates = append(exDates, mustDate(t, d))
+		}
+		got := NewRecurrence(rule, start, exDates).Between(start, start.AddDate(200, 0, 0), 10)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s from %s = %v, want %v", tt.rule, tt.start, got, tt.want)
+			continue
+		}
+		for i, o := range got {
+			if want := mustDate(t, tt.want[i]).Add(9*time.Hour + 30*time.Minute); !o.Equal(want) {
+				t.Errorf("%s from %s: occurrence %d = %v, want %v", tt.rule, tt.start, i, o, want)
+			}
+		}
+	}
+}
+
+func TestRecurrenceAfter(t *testing.T) {
+	rule, err := ParseRRule("FREQ=MONTHLY;BYMONTHDAY=15;COUNT=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRecurrence(rule, mustDate(t, "2024-01-15"), nil)
+	tests := []struct {
+		after  string
+		want   string
+		wantOK bool
+	}{
+		{"2024-01-01", "2024-01-15", true},
+		{"2024-01-15", "2024-02-15", true},
+		{"2024-02-20", "2024-03-15", true},
+		{"2024-03-15", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := rc.After(mustDate(t, tt.after))
+		if ok != tt.wantOK || ok && !got.Equal(mustDate(t, tt.want)) {
+			t.Errorf("After(%s) = %v, %v; want %s, %v", tt.after, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func mustDate(t *testing.T, s string) time.Time {
+	t.Helper()
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Tags is a set of transaction labels, stored as a comma separated list.
+type Tags []string
+
+func (t Tags) has(tag string) bool {
+	for _, v := range t {
+		if strings.EqualFold(v, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Tags) MarshalJSON() ([]byte, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t Tags) Value() (driver.Value, error) {
+	return strings.Join(t, ","), nil
+}
+
+func (t *Tags) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", src)
+	}
+	list := Tags{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	*t = list
+	return nil
+}
+
+// normalizeTags trims the tags and drops empty and repeated ones, comparing
+// without case.
+func normalizeTags(tags Tags) (Tags, error) {
+	list := Tags{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag %q cannot contain a comma", tag)
+		}
+		if len(tag) > 50 {
+			return nil, fmt.Errorf("tag %q is longer than 50 characters", tag)
+		}
+		if tag != "" && !list.has(tag) {
+			list = append(list, tag)
+		}
+	}
+	return list, nil
+}
+
+// Rule categorizes, renames or tags the transactions it matches when they
+// are created or imported. A rule matches when every condition it sets
+// holds: the name contains NameContains (ignoring case) and matches
+// NamePattern, the signed amount lies within MinAmount and MaxAmount, the
+// transaction is booked to AccountID and its sign is Sign (income or
+// expense).
+//
+// Rules run from the highest Priority down, ties in creation order. A field
+// is set by the first matching rule that sets it and tags accumulate; a
+// matching rule with Stop ends the run.
+type Rule struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	Name          string    `gorm:"size:255;not null" json:"name"`
+	Priority      int       `gorm:"not null;default:0" json:"priority"`
+	Disabled      bool      `gorm:"not null" json:"disabled"`
+	Stop          bool      `gorm:"not null" json:"stop"`
+	NameContains  string    `gorm:"size:255" json:"name_contains"`
+	NamePattern   string    `gorm:"size:255" json:"name_pattern"`
+	MinAmount     *Money    `gorm:"type:decimal(12,2)" json:"min_amount"`
+	MaxAmount     *Money    `gorm:"type:decimal(12,2)" json:"max_amount"`
+	AccountID     *uint     `json:"account_id"`
+	Sign          string    `gorm:"size:10" json:"sign"`
+	SetCategoryID *uint     `json:"set_category_id"`
+	SetName       string    `gorm:"size:255" json:"set_name"`
+	AddTags       Tags      `gorm:"type:text" json:"add_tags"`
+	UserID        uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	pattern *regexp.Regexp
+}
+
+// normalize rejects rules that match everything, do nothing or carry an
+// invalid pattern, and compiles the pattern.
+func (r *Rule) normalize() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	r.NameContains = strings.TrimSpace(r.NameContains)
+	r.SetName = strings.TrimSpace(r.SetName)
+	switch r.Sign {
+	case "", "income", "expense":
+	default:
+		return errors.New("sign must be income or expense")
+	}
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return errors.New("min_amount cannot exceed max_amount")
+	}
+	if r.NameContains == "" && r.NamePattern == "" && r.MinAmount == nil && r.MaxAmount == nil &&
+		r.AccountID == nil && r.Sign == "" {
+		return errors.New("a rule needs at least one condition")
+	}
+	var err error
+	if r.AddTags, err = normalizeTags(r.AddTags); err != nil {
+		return err
+	}
+	if r.SetCategoryID == nil && r.SetName == "" && len(r.AddTags) == 0 {
+		return errors.New("set set_category_id, set_name or add_tags")
+	}
+	return r.compile()
+}
+
+func (r *Rule) compile() error {
+	r.pattern = nil
+	if r.NamePattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(r.NamePattern)
+	if err != nil {
+		return fmt.Errorf("name_pattern: %v", err)
+	}
+	r.pattern = pattern
+	return nil
+}
+
+func (r *Rule) matches(t *Transaction) bool {
+	if r.NameContains != "" && !strings.Contains(strings.ToLower(t.Name), strings.ToLower(r.NameContains)) {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(t.Name) {
+		return false
+	}
+	if (r.MinAmount != nil && t.Amount < *r.MinAmount) || (r.MaxAmount != nil && t.Amount > *r.MaxAmount) {
+		return false
+	}
+	if r.AccountID != nil && (t.AccountID == nil || *t.AccountID != *r.AccountID) {
+		return false
+	}
+	switch r.Sign {
+	case "income":
+		return t.Amount > 0
+	case "expense":
+		return t.Amount < 0
+	}
+	return true
+}
+
+// loadRules loads the user's enabled rules in the order they run.
+func loadRules(db *gorm.DB, userID uint) ([]Rule, error) {
+	var rules []Rule
+	if err := db.Where("user_id = ? AND NOT disabled", userID).Order("priority DESC, id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	usable := rules[:0]
+	for _, r := range rules {
+		// a rule whose pattern no longer compiles cannot match anything
+		if r.compile() == nil {
+			usable = append(usable, r)
+		}
+	}
+	return usable, nil
+}
+
+// applyRules runs rules on t and returns the IDs of the rules that matched.
+// Matching runs on the name t came with, so a rename does not change what
+// later rules see. The category is only set on a transaction that has none
+// and is not split, unless recategorize is true.
+func applyRules(rules []Rule, t *Transaction, recategorize bool) []uint {
+	var matched []uint
+	original := *t
+	categorySet, nameSet := false, false
+	for i := range rules {
+		r := &rules[i]
+		if !r.matches(&original) {
+			continue
+		}
+		matched = append(matched, r.ID)
+		if r.SetCategoryID != nil && !categorySet && len(t.Splits) == 0 && (t.CategoryID == nil || recategorize) {
+			id := *r.SetCategoryID
+			t.CategoryID, t.Category = &id, nil
+			categorySet = true
+		}
+		if r.SetName != "" && !nameSet {
+			t.Name, nameSet = r.SetName, true
+		}
+		for _, tag := range r.AddTags {
+			if !t.Tags.has(tag) {
+				t.Tags = append(t.Tags, tag)
+			}
+		}
+		if r.Stop {
+			break
+		}
+	}
+	return matched
+}
+
+// checkRuleTargets makes sure the account and category of r are the user's.
+func (h *Handler) checkRuleTargets(userID uint, r *Rule) error {
+	var count int64
+	if r.AccountID != nil {
+		h.DB.Model(&Account{}).Where("id = ? AND user_id = ?", *r.AccountID, userID).Count(&count)
+		if count == 0 {
+			return errors.New("account not found")
+		}
+	}
+	if r.SetCategoryID != nil {
+		h.DB.Model(&Category{}).Where("id = ? AND user_id = ?", *r.SetCategoryID, userID).Count(&count)
+		if count == 0 {
+			return errors.New("category not found")
+		}
+	}
+	return nil
+}
+
+func (h *Handler) GetRules(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var rules []Rule
+	h.DB.Where("user_id = ?", userID).Order("priority DESC, id").Find(&rules)
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *Handler) CreateRule(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var rule Rule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.ID = 0
+	rule.UserID = userID
+	if err := rule.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.checkRuleTargets(userID, &rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *Handler) UpdateRule(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var rule Rule
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&rule).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	id := rule.ID
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.ID = id
+	rule.UserID = userID
+	if err := rule.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.checkRuleTargets(userID, &rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *Handler) DeleteRule(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	if result := h.DB.Where("user_id = ?", userID).Delete(&Rule{}, c.Param("id")); result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RuleChange is what applying the rules does to one transaction.
+type RuleChange struct {
+	TransactionID uint   `json:"transaction_id"`
+	Date          string `json:"date"`
+	Amount        Money  `json:"amount"`
+	Rules         []uint `json:"rules"`
+	OldName       string `json:"old_name"`
+	NewName       string `json:"new_name"`
+	OldCategoryID *uint  `json:"old_category_id"`
+	NewCategoryID *uint  `json:"new_category_id"`
+	OldTags       Tags   `json:"old_tags"`
+	NewTags       Tags   `json:"new_tags"`
+}
+
+// ApplyRules runs the rules over existing transactions. The body selects the
+// transactions (from and to, YYYY-MM-DD and inclusive, and account_id), the
+// rules (rule_ids, by default all enabled ones) and whether categorized
+// transactions are recategorized. With dry_run the changes are only listed;
+// otherwise they are saved, all or none. Reconciled transactions are left
+// alone.
+func (h *Handler) ApplyRules(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		RuleIDs      []uint `json:"rule_ids"`
+		From         string `json:"from"`
+		To           string `json:"to"`
+		AccountID    *uint  `json:"account_id"`
+		Recategorize bool   `json:"recategorize"`
+		DryRun       bool   `json:"dry_run"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var from, to *time.Time
+	for _, bound := range []struct {
+		name, value string
+		day         **time.Time
+	}{{"from", req.From, &from}, {"to", req.To, &to}} {
+		if bound.value == "" {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", bound.value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": bound.name + " must be a date (YYYY-MM-DD)"})
+			return
+		}
+		*bound.day = &day
+	}
+	rules, err := loadRules(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RuleIDs != nil {
+		selected := IDList(req.RuleIDs)
+		kept := rules[:0]
+		for _, r := range rules {
+			if selected.has(r.ID) {
+				kept = append(kept, r)
+			}
+		}
+		rules = kept
+	}
+	changes := []RuleChange{}
+	var changed []Transaction
+	var touches []budgetTouch
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// reconciled transactions are locked
+		query := tx.Where("user_id = ? AND status <> ?", userID, transactionReconciled)
+		if from != nil {
+			query = query.Where("date >= ?", *from)
+		}
+		if to != nil {
+			query = query.Where("date <= ?", *to)
+		}
+		if req.AccountID != nil {
+			query = query.Where("account_id = ?", *req.AccountID)
+		}
+		var transactions []Transaction
+		if err := query.Preload("Splits").Order("date, id").Find(&transactions).Error; err != nil {
+			return err
+		}
+		for i := range transactions {
+			t := &transactions[i]
+			before := *t
+			before.Tags = append(Tags{}, t.Tags...)
+			matched := applyRules(rules, t, req.Recategorize)
+			categoryChanged := (before.CategoryID == nil) != (t.CategoryID == nil) ||
+				(t.CategoryID != nil && *before.CategoryID != *t.CategoryID)
+			if !categoryChanged && before.Name == t.Name && len(before.Tags) == len(t.Tags) {
+				continue
+			}
+			changes = append(changes, RuleChange{
+				TransactionID: t.ID,
+				Date:          t.Date.Format("2006-01-02"),
+				Amount:        t.Amount,
+				Rules:         matched,
+				OldName:       before.Name,
+				NewName:       t.Name,
+				OldCategoryID: before.CategoryID,
+				NewCategoryID: t.CategoryID,
+				OldTags:       before.Tags,
+				NewTags:       t.Tags,
+			})
+			if categoryChanged {
+				touches = append(touches, transactionTouches(&before)...)
+				touches = append(touches, transactionTouches(t)...)
+			}
+			changed = append(changed, *t)
+		}
+		if req.DryRun {
+			return nil
+		}
+		for i := range changed {
+			t := &changed[i]
+			err := tx.Model(&Transaction{}).Where("id = ?", t.ID).
+				Updates(map[string]interface{}{"name": t.Name, "category_id": t.CategoryID, "tags": t.Tags}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.DryRun {
+		h.checkBudgetAlerts(userID, touches)
+	}
+	c.JSON(http.StatusOK, gin.H{"dry_run": req.DryRun, "changed": len(changes), "changes": changes})
+}
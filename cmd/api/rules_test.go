@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRuleMatches(t *testing.T) {
+	account, other := uint(1), uint(2)
+	min, max := Money(-10000), Money(-100)
+	tx := Transaction{Name: "POS 1234 Starbucks JKT", Amount: -500, AccountID: &account}
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"contains, any case", Rule{NameContains: "STARBUCKS"}, true},
+		{"does not contain", Rule{NameContains: "shell"}, false},
+		{"pattern", Rule{NamePattern: `^POS \d+ `}, true},
+		{"pattern is case sensitive", Rule{NamePattern: `starbucks`}, false},
+		{"amount within", Rule{MinAmount: &min, MaxAmount: &max}, true},
+		{"amount below the minimum", Rule{MinAmount: &max}, false},
+		{"amount above the maximum", Rule{MaxAmount: &min}, false},
+		{"account", Rule{AccountID: &account}, true},
+		{"other account", Rule{AccountID: &other}, false},
+		{"expense", Rule{Sign: "expense"}, true},
+		{"income", Rule{Sign: "income"}, false},
+		{"every condition", Rule{NameContains: "starbucks", AccountID: &account, Sign: "income"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.compile(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tt.rule.matches(&tx); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if (&Rule{AccountID: &account}).matches(&Transaction{Name: "Cash"}) {
+		t.Errorf("a rule on an account matches a transaction without one")
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	coffee, eatingOut, groceries := uint(10), uint(20), uint(30)
+	// in the order they run, highest priority first
+	rules := []Rule{
+		{ID: 1, NameContains: "coffee", SetCategoryID: &coffee, AddTags: Tags{"food"}},
+		{ID: 2, NameContains: "starbucks", SetCategoryID: &eatingOut, SetName: "Starbucks", AddTags: Tags{"Food", "coffee"}},
+		{ID: 3, NameContains: "starbucks coffee", SetName: "Starbucks Coffee"},
+		{ID: 4, Sign: "expense", AddTags: Tags{"spending"}, Stop: true},
+		{ID: 5, Sign: "expense", SetCategoryID: &groceries, AddTags: Tags{"never"}},
+	}
+	tests := []struct {
+		name         string
+		in           Transaction
+		recategorize bool
+		matched      []uint
+		categoryID   *uint
+		txName       string
+		tags         Tags
+	}{
+		{"first setter wins and tags add up", Transaction{Name: "STARBUCKS COFFEE", Amount: -500}, false,
+			[]uint{1, 2, 3, 4}, &coffee, "Starbucks", Tags{"food", "coffee", "spending"}},
+		{"matching uses the original name", Transaction{Name: "Starbucks", Amount: -500}, false,
+			[]uint{2, 4}, &eatingOut, "Starbucks", Tags{"Food", "coffee", "spending"}},
+		{"stop ends the run", Transaction{Name: "Shell", Amount: -500}, false,
+			[]uint{4}, nil, "Shell", Tags{"spending"}},
+		{"categorized stays", Transaction{Name: "Coffee", Amount: 500, CategoryID: &groceries}, false,
+			[]uint{1}, &groceries, "Coffee", Tags{"food"}},
+		{"recategorized", Transaction{Name: "Coffee", Amount: 500, CategoryID: &groceries}, true,
+			[]uint{1}, &coffee, "Coffee", Tags{"food"}},
+		{"split stays", Transaction{Name: "Coffee", Amount: 500, Splits: []TransactionSplit{{Amount: 500}}}, true,
+			[]uint{1}, nil, "Coffee", Tags{"food"}},
+		{"tags are not repeated", Transaction{Name: "Coffee", Amount: 500, Tags: Tags{"FOOD"}}, false,
+			[]uint{1}, &coffee, "Coffee", Tags{"FOOD"}},
+		{"no match", Transaction{Name: "Salary", Amount: 500}, false, nil, nil, "Salary", nil},
+	}
+	for _, tt := range tests {
+		tx := tt.in
+		matched := applyRules(rules, &tx, tt.recategorize)
+		if !reflect.DeepEqual(matched, tt.matched) {
+			t.Errorf("%s: matched %v, want %v", tt.name, matched, tt.matched)
+		}
+		if !reflect.DeepEqual(tx.CategoryID, tt.categoryID) || tx.Name != tt.txName || !reflect.DeepEqual(tx.Tags, tt.tags) {
+			t.Errorf("%s: got category %v, name %q, tags %q; want %v, %q, %q", tt.name,
+				tx.CategoryID, tx.Name, tx.Tags, tt.categoryID, tt.txName, tt.tags)
+		}
+	}
+}
+
+func TestApplyRulesHandler(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	account := testAccount(t, db, user.ID, accountChecking, 0)
+	var categories []Category
+	for _, name := range []string{"Coffee", "Eating out"} {
+		cat := Category{Name: name, UserID: user.ID}
+		if err := db.Create(&cat).Error; err != nil {
+			t.Fatal(err)
+		}
+		categories = append(categories, cat)
+	}
+	// created first but run second, for its lower priority
+	for _, r := range []Rule{
+		{Name: "coffee", Priority: 1, NameContains: "coffee", SetCategoryID: &categories[0].ID},
+		{Name: "starbucks", Priority: 5, NameContains: "starbucks", SetCategoryID: &categories[1].ID, AddTags: Tags{"out"}},
+	} {
+		r.UserID = user.ID
+		if err := db.Create(&r).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var transactions []Transaction
+	for _, tr := range []Transaction{
+		{Name: "Starbucks Coffee", Status: transactionCleared},
+		{Name: "Starbucks Coffee", Status: transactionReconciled},
+		{Name: "Rent", Status: transactionCleared},
+	} {
+		tr.Amount, tr.Currency, tr.Date, tr.UserID, tr.AccountID = -500, "IDR", mustDate(t, "2024-03-05"), user.ID, &account.ID
+		if err := db.Create(&tr).Error; err != nil {
+			t.Fatal(err)
+		}
+		transactions = append(transactions, tr)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		w := serve(h.ApplyRules, user.ID, "/", nil, gin.H{"dry_run": dryRun})
+		if w.Code != http.StatusOK {
+			t.Fatalf("dry_run %v: status = %d: %s", dryRun, w.Code, w.Body)
+		}
+		var resp struct {
+			Changed int          `json:"changed"`
+			Changes []RuleChange `json:"changes"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		// the reconciled line is left alone and the rent matches nothing
+		if resp.Changed != 1 || resp.Changes[0].TransactionID != transactions[0].ID ||
+			resp.Changes[0].NewCategoryID == nil || *resp.Changes[0].NewCategoryID != categories[1].ID {
+			t.Errorf("dry_run %v: changes = %+v, want the first line put into eating out", dryRun, resp.Changes)
+		}
+		var saved Transaction
+		db.First(&saved, transactions[0].ID)
+		if applied := saved.CategoryID != nil; applied == dryRun {
+			t.Errorf("dry_run %v: saved category = %v", dryRun, saved.CategoryID)
+		}
+	}
+}
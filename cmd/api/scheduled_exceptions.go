@@ -0,0 +1,215 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ScheduledException changes one occurrence of a scheduled transaction,
+// identified by the date the rule gives it, without touching the series: the
+// occurrence is skipped, or posted on MoveTo and/or with Amount instead.
+type ScheduledException struct {
+	ID                     uint       `gorm:"primaryKey" json:"id"`
+	ScheduledTransactionID uint       `gorm:"not null;uniqueIndex:idx_scheduled_exceptions_unique" json:"scheduled_transaction_id"`
+	OccursOn               time.Time  `gorm:"type:date;not null;uniqueIndex:idx_scheduled_exceptions_unique" json:"occurs_on"`
+	Skip                   bool       `gorm:"not null" json:"skip"`
+	MoveTo                 *time.Time `gorm:"type:date" json:"move_to"`
+	Amount                 *Money     `gorm:"type:decimal(12,2)" json:"amount"`
+	UserID                 uint       `gorm:"not null;index" json:"user_id"`
+	CreatedAt              time.Time  `json:"created_at"`
+	UpdatedAt              time.Time  `json:"updated_at"`
+}
+
+// pendingExceptionSQL selects the exceptions whose occurrence has not been
+// posted or skipped yet.
+const pendingExceptionSQL = "NOT EXISTS (SELECT 1 FROM scheduled_occurrences o" +
+	" WHERE o.scheduled_transaction_id = scheduled_exceptions.scheduled_transaction_id" +
+	" AND o.occurs_on = scheduled_exceptions.occurs_on)"
+
+// pendingExceptions loads the pending exceptions of the given scheduled
+// transactions, by scheduled transaction.
+func pendingExceptions(db *gorm.DB, ids []uint) (map[uint][]ScheduledException, error) {
+	byScheduled := map[uint][]ScheduledException{}
+	if len(ids) == 0 {
+		return byScheduled, nil
+	}
+	var exceptions []ScheduledException
+	err := db.Where("scheduled_transaction_id IN ? AND "+pendingExceptionSQL, ids).
+		Find(&exceptions).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, ex := range exceptions {
+		byScheduled[ex.ScheduledTransactionID] = append(byScheduled[ex.ScheduledTransactionID], ex)
+	}
+	return byScheduled, nil
+}
+
+// ScheduledInstance is an occurrence of a scheduled transaction with its
+// exception applied. OccursOn is the date the rule gives it and identifies
+// it; DueAt is when it is posted.
+type ScheduledInstance struct {
+	OccursOn   time.Time `json:"occurs_on"`
+	DueAt      time.Time `json:"due_at"`
+	Amount     Money     `json:"amount"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	Moved      bool      `json:"moved,omitempty"`
+	Overridden bool      `json:"overridden,omitempty"`
+}
+
+// instances applies the pending exceptions to the rule occurrences in
+// ruleDates. Occurrences moved away are dropped from their rule date, and
+// every occurrence moved to a day up to to is added, however early its rule
+// date, since a pending one has not been posted yet. The result is ordered
+// by DueAt.
+func (st *ScheduledTransaction) instances(rec *Recurrence, ruleDates []time.Time, exceptions []ScheduledException, to time.Time) []ScheduledInstance {
+	byDate := map[time.Time]ScheduledException{}
+	for _, ex := range exceptions {
+		byDate[civil(ex.OccursOn)] = ex
+	}
+	instance := func(occursOn, dueAt time.Time, ex *ScheduledException) ScheduledInstance {
+		in := ScheduledInstance{OccursOn: occursOn, DueAt: dueAt, Amount: st.Amount}
+		if ex != nil {
+			in.Skipped = ex.Skip
+			in.Moved = ex.MoveTo != nil
+			if ex.Amount != nil {
+				in.Amount, in.Overridden = *ex.Amount, true
+			}
+		}
+		return in
+	}
+	var result []ScheduledInstance
+	for _, at := range ruleDates {
+		date := civil(at)
+		ex, ok := byDate[date]
+		switch {
+		case !ok:
+			result = append(result, instance(date, at, nil))
+		case ex.MoveTo == nil:
+			result = append(result, instance(date, at, &ex))
+		}
+	}
+	for i := range exceptions {
+		ex := &exceptions[i]
+		if ex.MoveTo == nil || ex.Skip {
+			continue
+		}
+		if dueAt := rec.at(civil(*ex.MoveTo)); !dueAt.After(to) {
+			result = append(result, instance(civil(ex.OccursOn), dueAt, ex))
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].DueAt.Before(result[j].DueAt) })
+	return result
+}
+
+type ScheduledExceptionRequest struct {
+	Skip   bool       `json:"skip"`
+	MoveTo *time.Time `json:"move_to"`
+	Amount *Money     `json:"amount"`
+}
+
+func (h *Handler) GetScheduledExceptions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var exceptions []ScheduledException
+	h.DB.Where("scheduled_transaction_id = ? AND user_id = ?", c.Param("id"), userID).Order("occurs_on").Find(&exceptions)
+	c.JSON(http.StatusOK, exceptions)
+}
+
+// loadOccurrence finds the scheduled transaction and the rule occurrence on
+// the :date of the request, answering the request itself when either is
+// missing.
+func (h *Handler) loadOccurrence(c *gin.Context) (*ScheduledTransaction, time.Time, bool) {
+	userID := c.GetUint("user_id")
+	var st ScheduledTransaction
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&st).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return nil, time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", c.Param("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "date must be YYYY-MM-DD"})
+		return nil, time.Time{}, false
+	}
+	return &st, date, true
+}
+
+// SetScheduledException skips, moves or changes the amount of the
+// occurrence that the rule puts on :date. Occurrences already posted cannot
+// be changed any more.
+func (h *Handler) SetScheduledException(c *gin.Context) {
+	st, date, ok := h.loadOccurrence(c)
+	if !ok {
+		return
+	}
+	var req ScheduledExceptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.Skip && req.MoveTo == nil && req.Amount == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "set skip, move_to or amount"})
+		return
+	}
+	if req.Skip && (req.MoveTo != nil || req.Amount != nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a skipped occurrence cannot be moved or changed"})
+		return
+	}
+	rec, err := st.recurrence()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rec.Between(date, date.AddDate(0, 0, 1).Add(-time.Nanosecond), 1)) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "the schedule has no occurrence on " + date.Format("2006-01-02")})
+		return
+	}
+	var posted int64
+	h.DB.Model(&ScheduledOccurrence{}).Where("scheduled_transaction_id = ? AND occurs_on = ?", st.ID, date).Count(&posted)
+	if posted > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "this occurrence has already been posted"})
+		return
+	}
+	ex := ScheduledException{
+		ScheduledTransactionID: st.ID,
+		OccursOn:               date,
+		Skip:                   req.Skip,
+		Amount:                 req.Amount,
+		UserID:                 st.UserID,
+	}
+	if req.MoveTo != nil {
+		moveTo := dateOnly(*req.MoveTo)
+		ex.MoveTo = &moveTo
+	}
+	err = h.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scheduled_transaction_id"}, {Name: "occurs_on"}},
+		DoUpdates: clause.AssignmentColumns([]string{"skip", "move_to", "amount", "updated_at"}),
+	}).Create(&ex).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ex)
+}
+
+// DeleteScheduledException restores the occurrence on :date to the series.
+func (h *Handler) DeleteScheduledException(c *gin.Context) {
+	st, date, ok := h.loadOccurrence(c)
+	if !ok {
+		return
+	}
+	result := h.DB.Where("scheduled_transaction_id = ? AND occurs_on = ?", st.ID, date).Delete(&ScheduledException{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No exception for this occurrence"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScheduledInstances(t *testing.T) {
+	at := func(s string) time.Time { return mustDate(t, s).Add(9 * time.Hour) }
+	st := &ScheduledTransaction{Amount: -1000, Repetition: "weekly", StartsAt: at("2024-03-04"), RepeatAt: at("2024-03-04")}
+	rec, err := st.recurrence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := mustDate(t, "2024-03-31")
+	moveIn, moveOut, amount := mustDate(t, "2024-03-12"), mustDate(t, "2024-04-05"), Money(-2500)
+	exceptions := []ScheduledException{
+		// an earlier occurrence still pending, moved into the range
+		{OccursOn: mustDate(t, "2024-03-04"), MoveTo: &moveIn},
+		{OccursOn: mustDate(t, "2024-03-11"), Skip: true},
+		{OccursOn: mustDate(t, "2024-03-18"), MoveTo: &moveOut},
+		{OccursOn: mustDate(t, "2024-03-25"), Amount: &amount},
+	}
+	got := st.instances(rec, rec.Between(mustDate(t, "2024-03-10"), to, 10), exceptions, to)
+	want := []ScheduledInstance{
+		{OccursOn: mustDate(t, "2024-03-11"), DueAt: at("2024-03-11"), Amount: -1000, Skipped: true},
+		{OccursOn: mustDate(t, "2024-03-04"), DueAt: at("2024-03-12"), Amount: -1000, Moved: true},
+		{OccursOn: mustDate(t, "2024-03-25"), DueAt: at("2024-03-25"), Amount: -2500, Overridden: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("instances =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestSetScheduledException(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	start := mustDate(t, "2024-03-04")
+	st := ScheduledTransaction{Name: "Rent", Amount: -1000, Repetition: "weekly", StartsAt: start, RepeatAt: start, UserID: user.ID}
+	if err := db.Create(&st).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&ScheduledOccurrence{ScheduledTransactionID: st.ID, OccursOn: start}).Error; err != nil {
+		t.Fatal(err)
+	}
+	moveTo := mustDate(t, "2024-03-13")
+	tests := []struct {
+		name string
+		date string
+		body ScheduledExceptionRequest
+		want int
+	}{
+		{"not a date", "11-03-2024", ScheduledExceptionRequest{Skip: true}, http.StatusBadRequest},
+		{"no occurrence", "2024-03-12", ScheduledExceptionRequest{Skip: true}, http.StatusBadRequest},
+		{"nothing to change", "2024-03-11", ScheduledExceptionRequest{}, http.StatusBadRequest},
+		{"skip and move", "2024-03-11", ScheduledExceptionRequest{Skip: true, MoveTo: &moveTo}, http.StatusBadRequest},
+		{"already posted", "2024-03-04", ScheduledExceptionRequest{Skip: true}, http.StatusConflict},
+		{"move", "2024-03-11", ScheduledExceptionRequest{MoveTo: &moveTo}, http.StatusOK},
+		{"skip instead", "2024-03-11", ScheduledExceptionRequest{Skip: true}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		params := gin.Params{{Key: "id", Value: fmt.Sprint(st.ID)}, {Key: "date", Value: tt.date}}
+		w := serve(h.SetScheduledException, user.ID, "/", params, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+	var exceptions []ScheduledException
+	db.Where("scheduled_transaction_id = ?", st.ID).Find(&exceptions)
+	if len(exceptions) != 1 || !exceptions[0].Skip || exceptions[0].MoveTo != nil {
+		t.Errorf("exceptions = %+v, want the occurrence of 2024-03-11 skipped", exceptions)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultSchedulerInterval = time.Minute
+	// scheduledBatchSize bounds how many rows one database transaction locks.
+	scheduledBatchSize = 100
+	// scheduledCatchUpLimit bounds how many missed occurrences of one
+	// scheduled transaction a single run posts.
+	scheduledCatchUpLimit = 500
+)
+
+// repetitionRules maps the Repetition shorthands to recurrence rules. A
+// scheduled transaction with its own RRule has Repetition "custom".
+var repetitionRules = map[string]string{
+	"weekly":            "FREQ=WEEKLY",
+	"2 weeks":           "FREQ=WEEKLY;INTERVAL=2",
+	"monthly":           "FREQ=MONTHLY",
+	"3 months":          "FREQ=MONTHLY;INTERVAL=3",
+	"6 months":          "FREQ=MONTHLY;INTERVAL=6",
+	"annually":          "FREQ=YEARLY",
+	"last business day": "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+	"15th and last day": "FREQ=MONTHLY;BYMONTHDAY=15,-1",
+}
+
+const customRepetition = "custom"
+
+// repetitionRRule returns the rule of a Repetition shorthand for a schedule
+// starting at start. Monthly and yearly schedules starting after the 28th
+// fall on the last day of shorter months.
+func repetitionRRule(repetition string, start time.Time) (string, error) {
+	rule, ok := repetitionRules[repetition]
+	if !ok {
+		return "", errors.New("repetition must be custom (with an rrule) or one of weekly, 2 weeks, monthly, 3 months, 6 months, annually, last business day or 15th and last day")
+	}
+	if day := start.Day(); day > 28 && !strings.Contains(rule, "BY") {
+		switch {
+		case strings.HasPrefix(rule, "FREQ=MONTHLY"):
+			rule += fmt.Sprintf(";BYMONTHDAY=%d,-1;BYSETPOS=1", day)
+		case strings.HasPrefix(rule, "FREQ=YEARLY"):
+			rule += fmt.Sprintf(";BYMONTH=%d;BYMONTHDAY=%d,-1;BYSETPOS=1", start.Month(), day)
+		}
+	}
+	return rule, nil
+}
+
+// recurrence returns the occurrences of st: its RRule, or else the rule of
+// its Repetition shorthand, anchored at StartsAt.
+func (st *ScheduledTransaction) recurrence() (*Recurrence, error) {
+	rule := st.RRule
+	if rule == "" {
+		var err error
+		if rule, err = repetitionRRule(st.Repetition, st.StartsAt); err != nil {
+			return nil, err
+		}
+	}
+	parsed, err := ParseRRule(rule)
+	if err != nil {
+		return nil, fmt.Errorf("rrule: %w", err)
+	}
+	return NewRecurrence(parsed, st.StartsAt, st.ExDates), nil
+}
+
+// normalizeScheduled checks the recurrence of st and fills in the defaults:
+// StartsAt defaults to RepeatAt, and RepeatAt becomes the first occurrence
+// on or after it. A schedule with no occurrence left is Completed.
+func normalizeScheduled(st *ScheduledTransaction) error {
+	if st.RRule != "" {
+		st.RRule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(st.RRule)), "RRULE:")
+		st.Repetition = customRepetition
+	} else if st.Repetition == customRepetition {
+		return errors.New("a custom repetition needs an rrule")
+	}
+	if st.StartsAt.IsZero() {
+		st.StartsAt = st.RepeatAt
+	}
+	if st.StartsAt.IsZero() {
+		return errors.New("starts_at or repeat_at is required")
+	}
+	if st.RepeatAt.IsZero() || st.RepeatAt.Before(st.StartsAt) {
+		st.RepeatAt = st.StartsAt
+	}
+	rec, err := st.recurrence()
+	if err != nil {
+		return err
+	}
+	next, ok := rec.After(st.RepeatAt.Add(-time.Nanosecond))
+	st.Completed = !ok
+	if ok {
+		st.RepeatAt = next
+	}
+	return nil
+}
+
+// ScheduledOccurrence records that the occurrence of a scheduled transaction
+// due on OccursOn has been posted, or skipped when TransactionID is nil. The
+// unique key on both columns means an occurrence is never posted twice, even
+// when RepeatAt is moved back.
+type ScheduledOccurrence struct {
+	ID                     uint      `gorm:"primaryKey" json:"id"`
+	ScheduledTransactionID uint      `gorm:"not null;uniqueIndex:idx_scheduled_occurrences_unique" json:"scheduled_transaction_id"`
+	OccursOn               time.Time `gorm:"type:date;not null;uniqueIndex:idx_scheduled_occurrences_unique" json:"occurs_on"`
+	TransactionID          *uint     `gorm:"index" json:"transaction_id"`
+	CreatedAt              time.Time `json:"created_at"`
+}
+
+// postScheduled books every occurrence of st due at now, each dated on its
+// own due date, and moves RepeatAt on to the first occurrence after now, or
+// marks st Completed when the rule has ended. Exceptions are honored:
+// skipped occurrences are recorded without a transaction, moved ones are
+// posted once their new date is due and overridden ones with their own
+// amount. It returns the transactions it created; occurrences that already
+// have an idempotency record are skipped. At most scheduledCatchUpLimit rule
+// occurrences are posted per call, the rest follow on the next run.
+func (h *Handler) postScheduled(tx *gorm.DB, st *ScheduledTransaction, now time.Time) ([]Transaction, error) {
+	rec, err := st.recurrence()
+	if err != nil {
+		return nil, err
+	}
+	currency, err := h.transactionCurrency(tx, st.UserID, st.AccountID, "")
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := pendingExceptions(tx, []uint{st.ID})
+	if err != nil {
+		return nil, err
+	}
+	var due []time.Time
+	if !st.Completed {
+		due = rec.Between(st.RepeatAt, now, scheduledCatchUpLimit)
+	}
+	var posted []Transaction
+	for _, in := range st.instances(rec, due, exceptions[st.ID], now) {
+		occurrence := ScheduledOccurrence{ScheduledTransactionID: st.ID, OccursOn: in.OccursOn}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&occurrence)
+		if result.Error != nil {
+			return posted, result.Error
+		}
+		if result.RowsAffected == 0 || in.Skipped {
+			continue
+		}
+		t := Transaction{
+			Name:       st.Name,
+			Amount:     in.Amount,
+			Currency:   currency,
+			Date:       dateOnly(in.DueAt),
+			UserID:     st.UserID,
+			CategoryID: st.CategoryID,
+			AccountID:  st.AccountID,
+		}
+		if err := tx.Create(&t).Error; err != nil {
+			return posted, err
+		}
+		if err := adjustBalance(tx, st.UserID, st.AccountID, in.Amount); err != nil {
+			return posted, err
+		}
+		if err := tx.Model(&occurrence).Update("transaction_id", t.ID).Error; err != nil {
+			return posted, err
+		}
+		posted = append(posted, t)
+	}
+	if st.Completed {
+		return posted, nil
+	}
+	after := now
+	if len(due) == scheduledCatchUpLimit {
+		after = due[len(due)-1]
+	}
+	next, ok := rec.After(after)
+	if ok {
+		st.RepeatAt = next
+	}
+	st.Completed = !ok
+	return posted, tx.Model(st).Select("repeat_at", "completed").Updates(st).Error
+}
+
+// processDueScheduled posts every occurrence due at now of the scheduled
+// transactions of one user, or of everyone when userID is 0, including
+// occurrences moved to a date up to now, and returns how many transactions
+// were created. Rows are locked with FOR UPDATE SKIP LOCKED, so concurrent
+// runs on several server instances never post the same occurrence twice. A
+// row that fails is logged and left for the next run.
+func (h *Handler) processDueScheduled(ctx context.Context, userID uint, now time.Time) (int, error) {
+	posted := 0
+	var lastID uint
+	for ctx.Err() == nil {
+		var batch int
+		touched := map[uint][]budgetTouch{}
+		err := h.DB.Transaction(func(tx *gorm.DB) error {
+			query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("((NOT completed AND repeat_at <= ?) OR EXISTS (SELECT 1 FROM scheduled_exceptions"+
+					" WHERE scheduled_exceptions.scheduled_transaction_id = scheduled_transactions.id"+
+					" AND NOT skip AND move_to <= ? AND "+pendingExceptionSQL+")) AND id > ?", now, now, lastID)
+			if userID != 0 {
+				query = query.Where("user_id = ?", userID)
+			}
+			var due []ScheduledTransaction
+			if err := query.Order("id").Limit(scheduledBatchSize).Find(&due).Error; err != nil {
+				return err
+			}
+			batch = len(due)
+			for i := range due {
+				st := &due[i]
+				lastID = st.ID
+				// a savepoint per row keeps one bad row from undoing the batch
+				var created []Transaction
+				err := tx.Transaction(func(tx *gorm.DB) error {
+					var err error
+					created, err = h.postScheduled(tx, st, now)
+					return err
+				})
+				if err != nil {
+					log.Printf("scheduled transaction %d: %v", st.ID, err)
+					continue
+				}
+				posted += len(created)
+				for j := range created {
+					touched[st.UserID] = append(touched[st.UserID], transactionTouches(&created[j])...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return posted, err
+		}
+		// budget alerts see the batch once it is committed
+		for id, touches := range touched {
+			h.checkBudgetAlerts(id, touches)
+		}
+		if batch < scheduledBatchSize {
+			break
+		}
+	}
+	return posted, nil
+}
+
+// RunScheduler posts due scheduled transactions for all users every interval
+// until ctx is cancelled. A run in progress finishes its current batch
+// before RunScheduler returns.
+func (h *Handler) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		posted, err := h.processDueScheduled(ctx, 0, time.Now())
+		if err != nil {
+			log.Printf("scheduler: %v", err)
+		} else if posted > 0 {
+			log.Printf("scheduler: posted %d scheduled transactions", posted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const (
+	defaultPreviewLimit = 12
+	maxPreviewLimit     = 366
+)
+
+// previewOccurrences lists the upcoming occurrences of st from RepeatAt,
+// optionally only up to the until query parameter, with the exceptions
+// applied. Skipped occurrences are listed too, flagged as such.
+func previewOccurrences(c *gin.Context, st *ScheduledTransaction, exceptions []ScheduledException) ([]ScheduledInstance, error) {
+	limit := defaultPreviewLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPreviewLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPreviewLimit)
+		}
+		limit = n
+	}
+	until := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	if v := c.Query("until"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return nil, errors.New("until must be a date (YYYY-MM-DD)")
+		}
+		until = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	rec, err := st.recurrence()
+	if err != nil {
+		return nil, err
+	}
+	var ruleDates []time.Time
+	if !st.Completed {
+		ruleDates = rec.Between(st.RepeatAt, until, limit)
+	}
+	occurrences := st.instances(rec, ruleDates, exceptions, until)
+	if len(occurrences) > limit {
+		occurrences = occurrences[:limit]
+	}
+	if occurrences == nil {
+		occurrences = []ScheduledInstance{}
+	}
+	return occurrences, nil
+}
+
+// PreviewScheduledTransaction lists the upcoming occurrences of a saved
+// scheduled transaction, the same ones processing will post, with their
+// exceptions applied.
+func (h *Handler) PreviewScheduledTransaction(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var st ScheduledTransaction
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&st).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	exceptions, err := pendingExceptions(h.DB, []uint{st.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	occurrences, err := previewOccurrences(c, &st, exceptions[st.ID])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"occurrences": occurrences})
+}
+
+// PreviewSchedule lists the occurrences of a schedule before it is saved.
+// The body is a scheduled transaction; only its recurrence fields matter.
+func (h *Handler) PreviewSchedule(c *gin.Context) {
+	var st ScheduledTransaction
+	if err := c.ShouldBindJSON(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := normalizeScheduled(&st); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	occurrences, err := previewOccurrences(c, &st, nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"occurrences": occurrences})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPostScheduled(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	account := testAccount(t, db, user.ID, accountChecking, 0)
+	schedule := func(rrule string, start time.Time) *ScheduledTransaction {
+		st := &ScheduledTransaction{Name: "Rent", Amount: -1000, Repetition: customRepetition, RRule: rrule,
+			StartsAt: start, RepeatAt: start, UserID: user.ID, AccountID: &account.ID}
+		if err := db.Create(st).Error; err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	// four missed weeks and today are caught up, each on its own date
+	st := schedule("FREQ=WEEKLY", mustDate(t, "2024-01-01"))
+	now := mustDate(t, "2024-01-29").Add(12 * time.Hour)
+	posted, err := h.postScheduled(db, st, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dates []string
+	for _, tr := range posted {
+		dates = append(dates, tr.Date.Format("2006-01-02"))
+	}
+	want := []string{"2024-01-01", "2024-01-08", "2024-01-15", "2024-01-22", "2024-01-29"}
+	if len(dates) != len(want) {
+		t.Fatalf("posted %v, want %v", dates, want)
+	}
+	for i := range want {
+		if dates[i] != want[i] {
+			t.Errorf("posted %v, want %v", dates, want)
+			break
+		}
+	}
+	if !st.RepeatAt.Equal(mustDate(t, "2024-02-05")) || st.Completed {
+		t.Errorf("repeat_at = %s, completed %v; want 2024-02-05", st.RepeatAt, st.Completed)
+	}
+
+	// running again from the start posts nothing twice
+	st.RepeatAt = st.StartsAt
+	if posted, err := h.postScheduled(db, st, now); err != nil || len(posted) != 0 {
+		t.Errorf("second run posted %d, %v; want nothing", len(posted), err)
+	}
+	db.First(&account, account.ID)
+	if account.Amount != -5000 {
+		t.Errorf("balance = %s, want -50.00", account.Amount)
+	}
+
+	// years of missed days are posted scheduledCatchUpLimit at a time
+	daily := schedule("FREQ=DAILY", mustDate(t, "2020-01-01"))
+	posted, err = h.postScheduled(db, daily, mustDate(t, "2024-01-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := mustDate(t, "2020-01-01").AddDate(0, 0, scheduledCatchUpLimit)
+	if len(posted) != scheduledCatchUpLimit || !daily.RepeatAt.Equal(next) {
+		t.Errorf("posted %d up to %s, want %d up to %s", len(posted), daily.RepeatAt, scheduledCatchUpLimit, next)
+	}
+	posted, err = h.postScheduled(db, daily, mustDate(t, "2024-01-01"))
+	if err != nil || len(posted) != scheduledCatchUpLimit || !posted[0].Date.Equal(next) {
+		t.Errorf("next run posted %d, %v; want %d from %s", len(posted), err, scheduledCatchUpLimit, next)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const budgetingStandard = "standard"
+
+func settingsResponse(user *User) gin.H {
+	mode, start := budgetingStandard, ""
+	if user.EnvelopeStart != nil {
+		mode, start = budgetingEnvelope, user.EnvelopeStart.Format("2006-01")
+	}
+	return gin.H{"base_currency": user.BaseCurrency, "budgeting_mode": mode, "envelope_start": start}
+}
+
+func (h *Handler) GetSettings(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var user User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.BaseCurrency == "" {
+		user.BaseCurrency = defaultCurrency
+	}
+	c.JSON(http.StatusOK, settingsResponse(&user))
+}
+
+// UpdateSettings changes the base currency and the budgeting mode. Switching
+// to envelope budgeting starts in envelope_start (YYYY-MM), by default the
+// current month; switching back keeps the envelope allocations.
+func (h *Handler) UpdateSettings(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req struct {
+		BaseCurrency  string `json:"base_currency"`
+		BudgetingMode string `json:"budgeting_mode"`
+		EnvelopeStart string `json:"envelope_start"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	updates := map[string]interface{}{}
+	if req.BaseCurrency != "" {
+		base, err := normalizeCurrency(req.BaseCurrency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updates["base_currency"] = base
+	}
+	switch req.BudgetingMode {
+	case "":
+	case budgetingStandard:
+		updates["envelope_start"] = nil
+	case budgetingEnvelope:
+		start, err := envelopeMonthParam(req.EnvelopeStart)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "envelope_start: " + err.Error()})
+			return
+		}
+		if user.EnvelopeStart == nil || req.EnvelopeStart != "" {
+			updates["envelope_start"] = start
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "budgeting_mode must be standard or envelope"})
+		return
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "base_currency or budgeting_mode is required"})
+		return
+	}
+	if err := h.DB.Model(&user).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if v, ok := updates["envelope_start"].(time.Time); ok {
+		user.EnvelopeStart = &v
+	} else if _, ok := updates["envelope_start"]; ok {
+		user.EnvelopeStart = nil
+	}
+	if base, ok := updates["base_currency"].(string); ok {
+		user.BaseCurrency = base
+	}
+	c.JSON(http.StatusOK, settingsResponse(&user))
+}
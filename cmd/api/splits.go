@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionSplit is one category line of a transaction that spans several
+// categories. The lines of a transaction always add up to its amount.
+type TransactionSplit struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TransactionID uint      `gorm:"not null;index" json:"transaction_id"`
+	CategoryID    *uint     `gorm:"index" json:"category_id"`
+	Category      *Category `gorm:"foreignKey:CategoryID" json:"category,omitempty"`
+	Amount        Money     `gorm:"type:decimal(12,2);not null" json:"amount"`
+	Memo          string    `gorm:"size:255" json:"memo"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Reports attribute money per category line: every split line of a split
+// transaction, or the transaction itself when it has no splits. Use these
+// expressions together with categoryLines.
+const (
+	lineCategory = "CASE WHEN s.id IS NULL THEN t.category_id ELSE s.category_id END"
+	lineAmount   = "COALESCE(s.amount, t.amount)"
+)
+
+// categoryLines starts a query over the user's category lines.
+func (h *Handler) categoryLines(userID uint) *gorm.DB {
+	return h.DB.Table("transactions t").
+		Joins("LEFT JOIN transaction_splits s ON s.transaction_id = t.id").
+		Where("t.user_id = ?", userID)
+}
+
+// validateSplits checks that the split lines of t add up to its amount and
+// only use the user's categories. A split transaction has no category of its
+// own.
+func (h *Handler) validateSplits(t *Transaction) error {
+	if len(t.Splits) == 0 {
+		return nil
+	}
+	var sum Money
+	for i := range t.Splits {
+		s := &t.Splits[i]
+		s.ID = 0
+		s.TransactionID = t.ID
+		if s.Amount == 0 {
+			return fmt.Errorf("split %d: amount cannot be zero", i+1)
+		}
+		if s.CategoryID != nil {
+			var count int64
+			h.DB.Model(&Category{}).Where("id = ? AND user_id = ?", *s.CategoryID, t.UserID).Count(&count)
+			if count == 0 {
+				return fmt.Errorf("split %d: category not found", i+1)
+			}
+		}
+		sum += s.Amount
+	}
+	if sum != t.Amount {
+		return fmt.Errorf("split amounts add up to %s, expected %s", sum, t.Amount)
+	}
+	t.CategoryID = nil
+	return nil
+}
+
+// replaceSplits swaps the stored split lines of t for t.Splits.
+func replaceSplits(tx *gorm.DB, t *Transaction) error {
+	if err := tx.Where("transaction_id = ?", t.ID).Delete(&TransactionSplit{}).Error; err != nil {
+		return err
+	}
+	if len(t.Splits) == 0 {
+		return nil
+	}
+	for i := range t.Splits {
+		t.Splits[i].TransactionID = t.ID
+	}
+	return tx.Create(&t.Splits).Error
+}
+
+// CategorySpending is the spending attributed to one category in a period,
+// converted into the base currency. Uncategorized lines have no CategoryID.
+type CategorySpending struct {
+	CategoryID   *uint  `json:"category_id"`
+	CategoryName string `json:"category_name"`
+	Amount       Money  `json:"amount"`
+}
+
+// spendingByCategory sums expense lines dated between start and end
+// (inclusive) per category, largest first.
+func (h *Handler) spendingByCategory(userID uint, start, end time.Time, cv *currencyConverter) ([]CategorySpending, error) {
+	var rows []struct {
+		CategoryID *uint
+		Currency   string
+		Total      Money
+	}
+	h.categoryLines(userID).Where(lineAmount+" < 0 AND t.date BETWEEN ? AND ?", start, end).
+		Select(lineCategory + " AS category_id, t.currency AS currency, COALESCE(SUM(ABS(" + lineAmount + ")), 0) AS total").
+		Group(lineCategory + ", t.currency").Scan(&rows)
+	byCategory := map[uint]*CategorySpending{}
+	var ids []uint
+	for _, r := range rows {
+		amount, err := cv.Convert(r.Total, r.Currency)
+		if err != nil {
+			return nil, err
+		}
+		var key uint
+		if r.CategoryID != nil {
+			key = *r.CategoryID
+		}
+		cs, ok := byCategory[key]
+		if !ok {
+			cs = &CategorySpending{CategoryID: r.CategoryID, CategoryName: "Uncategorized"}
+			byCategory[key] = cs
+			if key != 0 {
+				ids = append(ids, key)
+			}
+		}
+		cs.Amount += amount
+	}
+	if len(ids) > 0 {
+		var categories []Category
+		h.DB.Where("id IN ? AND user_id = ?", ids, userID).Find(&categories)
+		for _, cat := range categories {
+			byCategory[cat.ID].CategoryName = cat.Name
+		}
+	}
+	result := make([]CategorySpending, 0, len(byCategory))
+	for _, cs := range byCategory {
+		result = append(result, *cs)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Amount > result[j].Amount })
+	return result, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSplits(t *testing.T) {
+	category := uint(7)
+	tests := []struct {
+		name   string
+		amount Money
+		splits []TransactionSplit
+		err    string
+	}{
+		{"no splits", -1000, nil, ""},
+		{"adds up", -1000, []TransactionSplit{{Amount: -600}, {Amount: -400}}, ""},
+		{"refund line", -1000, []TransactionSplit{{Amount: -1200}, {Amount: 200}}, ""},
+		{"too little", -1000, []TransactionSplit{{Amount: -600}, {Amount: -300}}, "split amounts add up to -9.00, expected -10.00"},
+		{"too much", -1000, []TransactionSplit{{Amount: -1000}, {Amount: -1}}, "split amounts add up to -10.01, expected -10.00"},
+		{"zero line", -1000, []TransactionSplit{{Amount: -1000}, {Amount: 0}}, "split 2: amount cannot be zero"},
+	}
+	h := &Handler{}
+	for _, tt := range tests {
+		tx := Transaction{ID: 3, Amount: tt.amount, CategoryID: &category, Splits: tt.splits}
+		err := h.validateSplits(&tx)
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s: validateSplits error = %v, want %q", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// a split transaction has no category of its own
+		if len(tt.splits) > 0 && tx.CategoryID != nil {
+			t.Errorf("%s: category = %d, want none", tt.name, *tx.CategoryID)
+		}
+		if len(tt.splits) == 0 && tx.CategoryID == nil {
+			t.Errorf("%s: category cleared without splits", tt.name)
+		}
+		for _, s := range tx.Splits {
+			if s.TransactionID != tx.ID {
+				t.Errorf("%s: split of transaction %d, want %d", tt.name, s.TransactionID, tx.ID)
+			}
+		}
+	}
+}
+
+func TestValidateSplitCategories(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user, stranger := testUser(t, db), testUser(t, db)
+	mine := Category{Name: "Groceries", UserID: user.ID}
+	theirs := Category{Name: "Groceries", UserID: stranger.ID}
+	for _, cat := range []*Category{&mine, &theirs} {
+		if err := db.Create(cat).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	tx := Transaction{Amount: -1000, UserID: user.ID, Splits: []TransactionSplit{
+		{Amount: -600, CategoryID: &mine.ID},
+		{Amount: -400},
+	}}
+	if err := h.validateSplits(&tx); err != nil {
+		t.Errorf("validateSplits with the user's category error = %v", err)
+	}
+	tx.Splits[1].CategoryID = &theirs.ID
+	if err := h.validateSplits(&tx); err == nil || !strings.Contains(err.Error(), "split 2: category not found") {
+		t.Errorf("validateSplits with another user's category error = %v, want category not found", err)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTransactionPageSize = 50
+	maxTransactionPageSize     = 200
+)
+
+// transactionSorts maps the sort query parameter to its column.
+var transactionSorts = map[string]string{
+	"date":       "date",
+	"amount":     "amount",
+	"name":       "name",
+	"created_at": "created_at",
+}
+
+// transactionCursor marks the last row of a page: the value of the sort column
+// and the id that breaks ties between equal values.
+type transactionCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+type transactionListQuery struct {
+	filter func(*gorm.DB) *gorm.DB
+	sort   string
+	desc   bool
+	limit  int
+	cursor *transactionCursor
+}
+
+// GetTransactions lists the user's transactions a page at a time.
+//
+// Filters: from and to (YYYY-MM-DD, inclusive booking dates), account_id,
+// category_id (also matches split lines), min_amount and max_amount, sign
+// (income or expense), q (case-insensitive name substring), tag and status
+// (uncleared, cleared or reconciled). Sorting uses sort (date, amount, name
+// or created_at) and order (asc or desc). Pass the returned next_cursor as
+// cursor to fetch the following page; total_count and totals describe the
+// whole filtered set.
+func (h *Handler) GetTransactions(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	q, err := parseTransactionListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filtered := func(db *gorm.DB) *gorm.DB {
+		return q.filter(db.Where("user_id = ?", userID))
+	}
+	direction, cmp := "ASC", ">"
+	if q.desc {
+		direction, cmp = "DESC", "<"
+	}
+	page := h.DB.Model(&Transaction{}).Scopes(filtered)
+	if q.cursor != nil {
+		value, err := cursorValue(q.sort, q.cursor.Value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		page = page.Where(fmt.Sprintf("((%[1]s %[2]s ?) OR (%[1]s = ? AND id %[2]s ?))", q.sort, cmp), value, value, q.cursor.ID)
+	}
+	var transactions []Transaction
+	err = page.Preload("Category").Preload("Account").Preload("Splits.Category").
+		Order(q.sort + " " + direction).Order("id " + direction).
+		Limit(q.limit + 1).Find(&transactions).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var nextCursor string
+	if len(transactions) > q.limit {
+		transactions = transactions[:q.limit]
+		nextCursor = encodeCursor(q.sort, transactions[q.limit-1])
+	}
+	var count int64
+	h.DB.Model(&Transaction{}).Scopes(filtered).Count(&count)
+	var totals []currencyTotal
+	h.DB.Model(&Transaction{}).Scopes(filtered).
+		Select("currency, COALESCE(SUM(amount), 0) AS total").Group("currency").Scan(&totals)
+	cv := h.newConverter(userID, time.Now())
+	response := gin.H{
+		"transactions":  transactions,
+		"next_cursor":   nextCursor,
+		"total_count":   count,
+		"totals":        totals,
+		"base_currency": cv.base,
+	}
+	// the converted total is only available when every currency has a rate
+	if total, rates, err := cv.Sum(totals); err == nil {
+		response["total_amount"] = total
+		response["rates"] = rates
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func parseTransactionListQuery(c *gin.Context) (transactionListQuery, error) {
+	q := transactionListQuery{sort: "date", desc: true, limit: defaultTransactionPageSize}
+	var conditions []func(*gorm.DB) *gorm.DB
+	where := func(query string, args ...interface{}) {
+		conditions = append(conditions, func(db *gorm.DB) *gorm.DB { return db.Where(query, args...) })
+	}
+	if v := c.Query("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return q, errors.New("from must be a date (YYYY-MM-DD)")
+		}
+		where("date >= ?", from)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return q, errors.New("to must be a date (YYYY-MM-DD)")
+		}
+		where("date <= ?", to)
+	}
+	if v := c.Query("account_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return q, errors.New("invalid account_id")
+		}
+		where("account_id = ?", id)
+	}
+	if v := c.Query("category_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return q, errors.New("invalid category_id")
+		}
+		where("(category_id = ? OR EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = transactions.id AND s.category_id = ?))", id, id)
+	}
+	if v := c.Query("min_amount"); v != "" {
+		minAmount, err := ParseMoney(v)
+		if err != nil {
+			return q, fmt.Errorf("min_amount: %w", err)
+		}
+		where("amount >= ?", minAmount)
+	}
+	if v := c.Query("max_amount"); v != "" {
+		maxAmount, err := ParseMoney(v)
+		if err != nil {
+			return q, fmt.Errorf("max_amount: %w", err)
+		}
+		where("amount <= ?", maxAmount)
+	}
+	switch c.Query("sign") {
+	case "":
+	case "income":
+		where("amount > 0")
+	case "expense":
+		where("amount < 0")
+	default:
+		return q, errors.New("sign must be income or expense")
+	}
+	if v := strings.TrimSpace(c.Query("q")); v != "" {
+		where("name ILIKE ?", "%"+escapeLike(v)+"%")
+	}
+	switch v := c.Query("status"); v {
+	case "":
+	case transactionUncleared, transactionCleared, transactionReconciled:
+		where("status = ?", v)
+	default:
+		return q, errors.New("status must be uncleared, cleared or reconciled")
+	}
+	if v := strings.TrimSpace(c.Query("tag")); v != "" {
+		where("(',' || tags || ',') ILIKE ?", "%,"+escapeLike(v)+",%")
+	}
+	if v := c.Query("sort"); v != "" {
+		column, ok := transactionSorts[v]
+		if !ok {
+			return q, errors.New("sort must be one of date, amount, name or created_at")
+		}
+		q.sort = column
+	}
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		q.desc = false
+	case "desc":
+		q.desc = true
+	default:
+		return q, errors.New("order must be asc or desc")
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTransactionPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxTransactionPageSize)
+		}
+		q.limit = limit
+	}
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		q.cursor = cursor
+	}
+	q.filter = func(db *gorm.DB) *gorm.DB {
+		for _, cond := range conditions {
+			db = cond(db)
+		}
+		return db
+	}
+	return q, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func encodeCursor(sort string, t Transaction) string {
+	cursor := transactionCursor{ID: t.ID}
+	switch sort {
+	case "date":
+		cursor.Value = t.Date.Format("2006-01-02")
+	case "amount":
+		cursor.Value = t.Amount.String()
+	case "name":
+		cursor.Value = t.Name
+	case "created_at":
+		cursor.Value = t.CreatedAt.Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*transactionCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cursor transactionCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// cursorValue converts a cursor value back into the type of the sort column.
+func cursorValue(sort, value string) (interface{}, error) {
+	switch sort {
+	case "date":
+		return time.Parse("2006-01-02", value)
+	case "amount":
+		return ParseMoney(value)
+	case "created_at":
+		return time.Parse(time.RFC3339Nano, value)
+	}
+	return value, nil
+}
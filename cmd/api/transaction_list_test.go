@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestTransactionCursor(t *testing.T) {
+	created := time.Date(2024, 3, 5, 10, 30, 15, 123456789, time.UTC)
+	tx := Transaction{ID: 42, Name: "Café, \"Bar\"", Amount: -1250, Date: mustDate(t, "2024-03-05"), CreatedAt: created}
+	tests := []struct {
+		sort  string
+		value string
+		typed interface{}
+	}{
+		{"date", "2024-03-05", mustDate(t, "2024-03-05")},
+		{"amount", "-12.50", Money(-1250)},
+		{"name", "Café, \"Bar\"", "Café, \"Bar\""},
+		{"created_at", "2024-03-05T10:30:15.123456789Z", created},
+	}
+	for _, tt := range tests {
+		cursor, err := decodeCursor(encodeCursor(tt.sort, tx))
+		if err != nil {
+			t.Errorf("%s: decodeCursor error = %v", tt.sort, err)
+			continue
+		}
+		if cursor.ID != tx.ID || cursor.Value != tt.value {
+			t.Errorf("%s: cursor = %+v, want %q and id %d", tt.sort, cursor, tt.value, tx.ID)
+		}
+		value, err := cursorValue(tt.sort, cursor.Value)
+		if err != nil || !reflect.DeepEqual(value, tt.typed) {
+			t.Errorf("%s: cursorValue = %v, %v; want %v", tt.sort, value, err, tt.typed)
+		}
+	}
+	for _, bad := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeCursor(bad); err == nil {
+			t.Errorf("decodeCursor(%q) error = nil, want an error", bad)
+		}
+	}
+	if _, err := cursorValue("amount", "1.234"); err == nil {
+		t.Errorf("cursorValue of a sub-cent amount error = nil, want an error")
+	}
+}
+
+func TestParseTransactionListQuery(t *testing.T) {
+	// a dry run renders the filters without a database
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		where string
+		err   string
+	}{
+		{"", "", ""},
+		{"from=2024-03-01&to=2024-03-31", ` WHERE date >= '2024-03-01 00:00:00' AND date <= '2024-03-31 00:00:00'`, ""},
+		{"account_id=3&sign=expense", ` WHERE account_id = 3 AND amount < 0`, ""},
+		{"category_id=4", ` WHERE (category_id = 4 OR EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = transactions.id AND s.category_id = 4))`, ""},
+		{"min_amount=-50&max_amount=-10.5", ` WHERE amount >= '-50.00' AND amount <= '-10.50'`, ""},
+		{"q=50%25_off&tag=Food&status=cleared", ` WHERE name ILIKE '%50\%\_off%' AND status = 'cleared' AND (',' || tags || ',') ILIKE '%,Food,%'`, ""},
+		{"from=03/01/2024", "", "from must be a date (YYYY-MM-DD)"},
+		{"account_id=x", "", "invalid account_id"},
+		{"min_amount=1.234", "", "min_amount: amount has more than two decimal places"},
+		{"sign=both", "", "sign must be income or expense"},
+		{"status=reconciling", "", "status must be uncleared, cleared or reconciled"},
+		{"sort=id", "", "sort must be one of date, amount, name or created_at"},
+		{"order=up", "", "order must be asc or desc"},
+		{"limit=0", "", "limit must be between 1 and 200"},
+		{"limit=201", "", "limit must be between 1 and 200"},
+		{"cursor=%21", "", "invalid cursor"},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		q, err := parseTransactionListQuery(c)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: error = %v, want %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.query, err)
+			continue
+		}
+		sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&Transaction{}).Scopes(q.filter).Find(&[]Transaction{})
+		})
+		if want := `SELECT * FROM "transactions"` + tt.where; sql != want {
+			t.Errorf("%s: query =\n%s\nwant\n%s", tt.query, sql, want)
+		}
+	}
+}
+
+func TestGetTransactionsPages(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	// ties on every sort column, so only the id keeps pages apart
+	var ids []uint
+	for i := 0; i < 7; i++ {
+		tr := Transaction{Name: "Coffee", Amount: -500, Currency: "IDR", Date: mustDate(t, "2024-03-05"), UserID: user.ID}
+		if i%2 == 1 {
+			tr.Amount, tr.Date = -700, mustDate(t, "2024-03-06")
+		}
+		if err := db.Create(&tr).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, tr.ID)
+	}
+	for _, order := range []string{"sort=date&order=desc", "sort=amount&order=asc", "sort=name&order=asc"} {
+		seen := map[uint]bool{}
+		var previous *Transaction
+		cursor := ""
+		for page := 0; ; page++ {
+			w := serve(h.GetTransactions, user.ID, "/?limit=3&"+order+"&cursor="+cursor, nil, nil)
+			if w.Code != http.StatusOK || page > len(ids) {
+				t.Fatalf("%s: status = %d: %s", order, w.Code, w.Body)
+			}
+			var resp struct {
+				Transactions []Transaction `json:"transactions"`
+				NextCursor   string        `json:"next_cursor"`
+				TotalCount   int64         `json:"total_count"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.TotalCount != int64(len(ids)) {
+				t.Errorf("%s: total_count = %d, want %d", order, resp.TotalCount, len(ids))
+			}
+			for i := range resp.Transactions {
+				tr := &resp.Transactions[i]
+				if seen[tr.ID] {
+					t.Errorf("%s: transaction %d on two pages", order, tr.ID)
+				}
+				seen[tr.ID] = true
+				if previous != nil && order == "sort=date&order=desc" && tr.Date.Equal(previous.Date) && tr.ID > previous.ID {
+					t.Errorf("%s: ties not ordered by id: %d after %d", order, tr.ID, previous.ID)
+				}
+				previous = tr
+			}
+			if resp.NextCursor == "" {
+				break
+			}
+			cursor = resp.NextCursor
+		}
+		if len(seen) != len(ids) {
+			t.Errorf("%s: paged through %d transactions, want %d", order, len(seen), len(ids))
+		}
+	}
+	if w := serve(h.GetTransactions, user.ID, fmt.Sprintf("/?sort=amount&cursor=%s", encodeCursor("name", Transaction{Name: "x"})), nil, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("a name cursor on the amount sort: status = %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Transfer moves money between two of the user's accounts. Amount and Fee are
+// debited from the source account in its currency and ToAmount is credited to
+// the destination account in its currency. Transfers, including their fee, are
+// not income or expenses and never count towards budgets.
+type Transfer struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	FromAccountID *uint     `gorm:"index" json:"from_account_id"`
+	FromAccount   *Account  `gorm:"foreignKey:FromAccountID" json:"from_account,omitempty"`
+	ToAccountID   *uint     `gorm:"index" json:"to_account_id"`
+	ToAccount     *Account  `gorm:"foreignKey:ToAccountID" json:"to_account,omitempty"`
+	Amount        Money     `gorm:"type:decimal(12,2);not null" json:"amount"`
+	ToAmount      Money     `gorm:"type:decimal(12,2);not null" json:"to_amount"`
+	Fee           Money     `gorm:"type:decimal(12,2);not null;default:0" json:"fee"`
+	Note          string    `gorm:"size:255" json:"note"`
+	Date          time.Time `gorm:"type:date;not null" json:"date"`
+	UserID        uint      `gorm:"not null;index" json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type TransferRequest struct {
+	FromAccountID uint   `json:"from_account_id" binding:"required"`
+	ToAccountID   uint   `json:"to_account_id" binding:"required"`
+	Amount        Money  `json:"amount" binding:"required"`
+	ToAmount      *Money `json:"to_amount"`
+	Fee           Money  `json:"fee"`
+	Note          string `json:"note"`
+	// Date defaults to today for a new transfer and to the current date of
+	// an updated one.
+	Date *time.Time `json:"date"`
+}
+
+// apply books the transfer against both account balances; sign -1 reverts it.
+func (t *Transfer) apply(tx *gorm.DB, sign Money) error {
+	if err := adjustBalance(tx, t.UserID, t.FromAccountID, -sign*(t.Amount+t.Fee)); err != nil {
+		return err
+	}
+	return adjustBalance(tx, t.UserID, t.ToAccountID, sign*t.ToAmount)
+}
+
+// transferToAmount is what a transfer credits to the destination account: the
+// amount itself between accounts of the same currency, otherwise the
+// to_amount the request must give.
+func transferToAmount(req TransferRequest, fromCurrency, toCurrency string) (Money, error) {
+	toAmount := req.Amount
+	if req.ToAmount != nil {
+		toAmount = *req.ToAmount
+	}
+	if fromCurrency == toCurrency && toAmount != req.Amount {
+		return 0, errors.New("to_amount must equal amount between accounts of the same currency")
+	}
+	if fromCurrency != toCurrency && req.ToAmount == nil {
+		return 0, errors.New("to_amount is required between accounts of different currencies")
+	}
+	if toAmount <= 0 {
+		return 0, errors.New("to_amount must be positive")
+	}
+	return toAmount, nil
+}
+
+// fillTransfer validates req against the user's accounts and copies it into t.
+func (h *Handler) fillTransfer(t *Transfer, req TransferRequest) error {
+	if req.FromAccountID == req.ToAccountID {
+		return errors.New("source and destination account must differ")
+	}
+	if req.Amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+	if req.Fee < 0 {
+		return errors.New("fee cannot be negative")
+	}
+	var from, to Account
+	if err := h.DB.Where("id = ? AND user_id = ?", req.FromAccountID, t.UserID).First(&from).Error; err != nil {
+		return errors.New("source account not found")
+	}
+	if err := h.DB.Where("id = ? AND user_id = ?", req.ToAccountID, t.UserID).First(&to).Error; err != nil {
+		return errors.New("destination account not found")
+	}
+	toAmount, err := transferToAmount(req, from.Currency, to.Currency)
+	if err != nil {
+		return err
+	}
+	t.FromAccountID = &from.ID
+	t.ToAccountID = &to.ID
+	t.Amount = req.Amount
+	t.ToAmount = toAmount
+	t.Fee = req.Fee
+	t.Note = req.Note
+	switch {
+	case req.Date != nil:
+		t.Date = dateOnly(*req.Date)
+	case t.Date.IsZero():
+		t.Date = dateOnly(time.Now())
+	}
+	return nil
+}
+
+func (h *Handler) GetTransfers(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var transfers []Transfer
+	h.DB.Where("user_id = ?", userID).Preload("FromAccount").Preload("ToAccount").Order("date DESC, id DESC").Find(&transfers)
+	c.JSON(http.StatusOK, transfers)
+}
+
+func (h *Handler) CreateTransfer(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	transfer := Transfer{UserID: userID}
+	if err := h.fillTransfer(&transfer, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&transfer).Error; err != nil {
+			return err
+		}
+		return transfer.apply(tx, 1)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, transfer)
+}
+
+func (h *Handler) UpdateTransfer(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var transfer Transfer
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&transfer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	old := transfer
+	if err := h.fillTransfer(&transfer, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// neither where it was nor where it goes may be reconciled
+		for _, t := range []*Transfer{&old, &transfer} {
+			if locked, err := transferReconciled(tx, t); err != nil {
+				return err
+			} else if locked {
+				return errTransferReconciled
+			}
+		}
+		if err := old.apply(tx, -1); err != nil {
+			return err
+		}
+		if err := transfer.apply(tx, 1); err != nil {
+			return err
+		}
+		return tx.Save(&transfer).Error
+	})
+	if errors.Is(err, errTransferReconciled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, transfer)
+}
+
+func (h *Handler) DeleteTransfer(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	var transfer Transfer
+	if err := h.DB.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&transfer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if locked, err := transferReconciled(tx, &transfer); err != nil {
+			return err
+		} else if locked {
+			return errTransferReconciled
+		}
+		if err := transfer.apply(tx, -1); err != nil {
+			return err
+		}
+		return tx.Delete(&transfer).Error
+	})
+	if errors.Is(err, errTransferReconciled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
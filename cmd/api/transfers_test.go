@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTransferToAmount(t *testing.T) {
+	same, other := Money(1000), Money(65)
+	tests := []struct {
+		name     string
+		req      TransferRequest
+		from, to string
+		want     Money
+		wantErr  bool
+	}{
+		{"same currency", TransferRequest{Amount: 1000}, "IDR", "IDR", 1000, false},
+		{"same currency, same to_amount", TransferRequest{Amount: 1000, ToAmount: &same}, "IDR", "IDR", 1000, false},
+		{"same currency, other to_amount", TransferRequest{Amount: 1000, ToAmount: &other}, "IDR", "IDR", 0, true},
+		{"cross currency", TransferRequest{Amount: 1000, ToAmount: &other}, "IDR", "USD", 65, false},
+		{"cross currency without to_amount", TransferRequest{Amount: 1000}, "IDR", "USD", 0, true},
+		{"cross currency, nothing arrives", TransferRequest{Amount: 1000, ToAmount: new(Money)}, "IDR", "USD", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := transferToAmount(tt.req, tt.from, tt.to)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: transferToAmount = %s, %v; want %s, wantErr %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTransferBalances(t *testing.T) {
+	db := testDB(t)
+	h := NewHandler(db)
+	user := testUser(t, db)
+	idr := testAccount(t, db, user.ID, accountChecking, 10000000)
+	savings := testAccount(t, db, user.ID, accountSavings, 0)
+	usd := Account{BankName: "USD", Type: accountSavings, Currency: "USD", UserID: user.ID}
+	if err := db.Create(&usd).Error; err != nil {
+		t.Fatal(err)
+	}
+	balances := func(step string, want ...Money) {
+		t.Helper()
+		for i, acc := range []Account{idr, savings, usd} {
+			db.First(&acc, acc.ID)
+			if acc.Amount != want[i] {
+				t.Errorf("%s: %s balance = %s, want %s", step, acc.BankName, acc.Amount, want[i])
+			}
+		}
+	}
+
+	// the fee is paid by the source account, the destination gets to_amount
+	w := serve(h.CreateTransfer, user.ID, "/", nil, gin.H{"from_account_id": idr.ID, "to_account_id": usd.ID, "amount": 15000, "fee": 5, "to_amount": 1})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", w.Code, w.Body)
+	}
+	var transfer Transfer
+	if err := json.Unmarshal(w.Body.Bytes(), &transfer); err != nil {
+		t.Fatal(err)
+	}
+	balances("create", 8499500, 0, 100)
+	id := gin.Params{{Key: "id", Value: fmt.Sprint(transfer.ID)}}
+
+	// an update reverts the old transfer before booking the new one
+	w = serve(h.UpdateTransfer, user.ID, "/", id, gin.H{"from_account_id": idr.ID, "to_account_id": usd.ID, "amount": 30000, "to_amount": 2})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: status = %d: %s", w.Code, w.Body)
+	}
+	balances("update", 7000000, 0, 200)
+	w = serve(h.UpdateTransfer, user.ID, "/", id, gin.H{"from_account_id": idr.ID, "to_account_id": savings.ID, "amount": 10})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update to another account: status = %d: %s", w.Code, w.Body)
+	}
+	balances("update to another account", 9999000, 1000, 0)
+
+	w = serve(h.DeleteTransfer, user.ID, "/", id, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body)
+	}
+	balances("delete", 10000000, 0, 0)
+
+	stranger := testUser(t, db)
+	for _, body := range []gin.H{
+		{"from_account_id": idr.ID, "to_account_id": idr.ID, "amount": 10},
+		{"from_account_id": idr.ID, "to_account_id": savings.ID, "amount": 10, "fee": -1},
+		{"from_account_id": idr.ID, "to_account_id": usd.ID, "amount": 10},
+		{"from_account_id": idr.ID, "to_account_id": testAccount(t, db, stranger.ID, accountChecking, 0).ID, "amount": 10},
+	} {
+		if w := serve(h.CreateTransfer, user.ID, "/", nil, body); w.Code != http.StatusBadRequest {
+			t.Errorf("create %v: status = %d, want 400", body, w.Code)
+		}
+	}
+	balances("refused", 10000000, 0, 0)
+}
@@ -1,3 +1,13 @@
+DROP TABLE IF EXISTS reconciliations CASCADE;
+DROP TABLE IF EXISTS rules CASCADE;
+DROP TABLE IF EXISTS notifications CASCADE;
+DROP TABLE IF EXISTS envelope_allocations CASCADE;
+DROP TABLE IF EXISTS scheduled_exceptions CASCADE;
+DROP TABLE IF EXISTS scheduled_occurrences CASCADE;
+DROP TABLE IF EXISTS import_profiles CASCADE;
+DROP TABLE IF EXISTS transaction_splits CASCADE;
+DROP TABLE IF EXISTS transfers CASCADE;
+DROP TABLE IF EXISTS exchange_rates CASCADE;
 DROP TABLE IF EXISTS scheduled_transactions CASCADE;
 DROP TABLE IF EXISTS budgets CASCADE;
 DROP TABLE IF EXISTS transactions CASCADE;
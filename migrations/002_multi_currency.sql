@@ -0,0 +1,21 @@
+-- user base currency used for converted totals
+ALTER TABLE users ADD COLUMN IF NOT EXISTS base_currency VARCHAR(3) NOT NULL DEFAULT 'IDR';
+
+-- account and transaction currency (ISO 4217 code)
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'IDR';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'IDR';
+
+-- exchange rate (id, from_currency, to_currency, rate, date): 1 from_currency = rate to_currency
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id SERIAL PRIMARY KEY,
+    from_currency VARCHAR(3) NOT NULL,
+    to_currency VARCHAR(3) NOT NULL,
+    rate DECIMAL(18, 8) NOT NULL,
+    date DATE NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, from_currency, to_currency, date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_exchange_rates_user_id ON exchange_rates(user_id);
@@ -0,0 +1,16 @@
+-- transfer (id, from_account_id, to_account_id, amount, to_amount, fee, note, created_at, updated_at)
+-- amount and fee are in the source account currency, to_amount in the destination currency
+CREATE TABLE IF NOT EXISTS transfers (
+    id SERIAL PRIMARY KEY,
+    from_account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+    to_account_id INTEGER REFERENCES accounts(id) ON DELETE SET NULL,
+    amount DECIMAL(12, 2) NOT NULL,
+    to_amount DECIMAL(12, 2) NOT NULL,
+    fee DECIMAL(12, 2) NOT NULL DEFAULT 0,
+    note VARCHAR(255),
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfers_user_id ON transfers(user_id);
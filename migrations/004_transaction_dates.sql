@@ -0,0 +1,16 @@
+-- booking date (date) and optional value date of a transaction, defaulting existing rows to their creation day
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS date DATE;
+UPDATE transactions SET date = created_at::date WHERE date IS NULL;
+ALTER TABLE transactions ALTER COLUMN date SET DEFAULT CURRENT_DATE;
+ALTER TABLE transactions ALTER COLUMN date SET NOT NULL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS value_date DATE;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_user_date ON transactions(user_id, date DESC);
+
+-- booking date of a transfer, likewise defaulting existing rows to their creation day
+ALTER TABLE transfers ADD COLUMN IF NOT EXISTS date DATE;
+UPDATE transfers SET date = created_at::date WHERE date IS NULL;
+ALTER TABLE transfers ALTER COLUMN date SET DEFAULT CURRENT_DATE;
+ALTER TABLE transfers ALTER COLUMN date SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_transfers_user_date ON transfers(user_id, date DESC);
@@ -0,0 +1,21 @@
+-- import profile (id, name, delimiter, has_header, skip_rows, date_column, date_format, amount_column, debit_column, credit_column, description_column, decimal_separator, encoding, user_id, created_at, updated_at)
+CREATE TABLE IF NOT EXISTS import_profiles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    delimiter VARCHAR(1) NOT NULL DEFAULT ',',
+    has_header BOOLEAN NOT NULL DEFAULT TRUE,
+    skip_rows INTEGER NOT NULL DEFAULT 0,
+    date_column VARCHAR(100) NOT NULL,
+    date_format VARCHAR(50) NOT NULL,
+    amount_column VARCHAR(100),
+    debit_column VARCHAR(100),
+    credit_column VARCHAR(100),
+    description_column VARCHAR(100),
+    decimal_separator VARCHAR(1) NOT NULL DEFAULT '.',
+    encoding VARCHAR(20) NOT NULL DEFAULT 'utf-8',
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_profiles_user_id ON import_profiles(user_id);
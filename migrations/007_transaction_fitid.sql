@@ -0,0 +1,4 @@
+-- transaction fitid: the bank's id of an imported OFX/QFX statement line, unique per account
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fitid VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_account_fitid ON transactions(account_id, fitid);
@@ -0,0 +1,2 @@
+-- the background scheduler looks up due scheduled transactions of all users
+CREATE INDEX IF NOT EXISTS idx_scheduled_transactions_repeat_at ON scheduled_transactions(repeat_at);
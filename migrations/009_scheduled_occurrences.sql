@@ -0,0 +1,11 @@
+-- scheduled occurrence (id, scheduled_transaction_id, occurs_on, transaction_id, created_at): one row per posted occurrence
+CREATE TABLE IF NOT EXISTS scheduled_occurrences (
+    id SERIAL PRIMARY KEY,
+    scheduled_transaction_id INTEGER NOT NULL REFERENCES scheduled_transactions(id) ON DELETE CASCADE,
+    occurs_on DATE NOT NULL,
+    transaction_id INTEGER REFERENCES transactions(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (scheduled_transaction_id, occurs_on)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_occurrences_transaction_id ON scheduled_occurrences(transaction_id);
@@ -0,0 +1,8 @@
+-- recurrence rule (RFC 5545 RRULE value), anchor date and excluded dates of a scheduled transaction;
+-- existing rows keep their repetition shorthand and are anchored at their next due date
+ALTER TABLE scheduled_transactions ADD COLUMN IF NOT EXISTS rrule VARCHAR(500);
+ALTER TABLE scheduled_transactions ADD COLUMN IF NOT EXISTS starts_at TIMESTAMP;
+UPDATE scheduled_transactions SET starts_at = repeat_at WHERE starts_at IS NULL;
+ALTER TABLE scheduled_transactions ALTER COLUMN starts_at SET NOT NULL;
+ALTER TABLE scheduled_transactions ADD COLUMN IF NOT EXISTS exdates TEXT;
+ALTER TABLE scheduled_transactions ADD COLUMN IF NOT EXISTS completed BOOLEAN NOT NULL DEFAULT FALSE;
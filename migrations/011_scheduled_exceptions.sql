@@ -0,0 +1,17 @@
+-- scheduled exception (id, scheduled_transaction_id, occurs_on, skip, move_to, amount, user_id, created_at, updated_at):
+-- one occurrence of a scheduled transaction skipped, moved to another date or posted with another amount
+CREATE TABLE IF NOT EXISTS scheduled_exceptions (
+    id SERIAL PRIMARY KEY,
+    scheduled_transaction_id INTEGER NOT NULL REFERENCES scheduled_transactions(id) ON DELETE CASCADE,
+    occurs_on DATE NOT NULL,
+    skip BOOLEAN NOT NULL DEFAULT FALSE,
+    move_to DATE,
+    amount DECIMAL(12,2),
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (scheduled_transaction_id, occurs_on)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_exceptions_user_id ON scheduled_exceptions(user_id);
+CREATE INDEX IF NOT EXISTS idx_scheduled_exceptions_move_to ON scheduled_exceptions(move_to) WHERE move_to IS NOT NULL;
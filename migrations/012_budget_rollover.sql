@@ -0,0 +1,2 @@
+-- rollover budgets carry each period's remaining amount, positive or negative, into the next period
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS rollover BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,7 @@
+-- period settings of weekly (week_start), payday (anchor_day) and custom (starts_on, ends_on) budgets;
+-- budgets without a known criteria become monthly, which is how they were computed
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS week_start VARCHAR(10);
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS anchor_day INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS starts_on DATE;
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS ends_on DATE;
+UPDATE budgets SET criteria = 'monthly' WHERE criteria NOT IN ('weekly', 'monthly', 'quarterly', 'annual', 'payday', 'custom');
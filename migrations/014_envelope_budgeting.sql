@@ -0,0 +1,16 @@
+-- envelope budgeting: users with an envelope_start month budget zero-based, assigning money to category envelopes
+ALTER TABLE users ADD COLUMN IF NOT EXISTS envelope_start DATE;
+
+-- envelope allocation (id, category_id, month, amount, user_id, created_at, updated_at): money assigned to a category for a month
+CREATE TABLE IF NOT EXISTS envelope_allocations (
+    id SERIAL PRIMARY KEY,
+    category_id INTEGER NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    month DATE NOT NULL,
+    amount DECIMAL(12,2) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (category_id, month)
+);
+
+CREATE INDEX IF NOT EXISTS idx_envelope_allocations_user_id ON envelope_allocations(user_id);
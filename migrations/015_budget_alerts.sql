@@ -0,0 +1,23 @@
+-- percentages of a budget at which a notification is raised; existing budgets warn at 80% and alert at 100%
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS alert_thresholds TEXT;
+UPDATE budgets SET alert_thresholds = '80,100' WHERE alert_thresholds IS NULL;
+
+-- notification (id, kind, level, title, message, budget_id, period_start, threshold, read_at, user_id, created_at): the in-app feed
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    level VARCHAR(20) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    message VARCHAR(500),
+    budget_id INTEGER REFERENCES budgets(id) ON DELETE SET NULL,
+    period_start DATE,
+    threshold INTEGER NOT NULL DEFAULT 0,
+    read_at TIMESTAMP,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, id);
+CREATE INDEX IF NOT EXISTS idx_notifications_budget_id ON notifications(budget_id);
+-- a threshold is notified once per budget period
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_budget_threshold ON notifications(budget_id, period_start, threshold) WHERE budget_id IS NOT NULL;
@@ -0,0 +1,7 @@
+-- a budget counts the spending of a set of categories and/or accounts, stored as comma separated ids
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS name VARCHAR(255);
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS category_ids TEXT;
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS account_ids TEXT;
+UPDATE budgets SET category_ids = COALESCE(category_id::text, '') WHERE category_ids IS NULL;
+UPDATE budgets SET account_ids = '' WHERE account_ids IS NULL;
+ALTER TABLE budgets DROP COLUMN IF EXISTS category_id;
@@ -0,0 +1,26 @@
+-- transaction tags, stored as a comma separated list
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tags TEXT NOT NULL DEFAULT '';
+
+-- rule (id, name, priority, disabled, stop, name_contains, name_pattern, min_amount, max_amount, account_id, sign, set_category_id, set_name, add_tags, user_id, created_at, updated_at): categorizes, renames and tags matching transactions
+CREATE TABLE IF NOT EXISTS rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    stop BOOLEAN NOT NULL DEFAULT FALSE,
+    name_contains VARCHAR(255),
+    name_pattern VARCHAR(255),
+    min_amount DECIMAL(12,2),
+    max_amount DECIMAL(12,2),
+    -- a rule loses its meaning with the account it matches or the category it sets
+    account_id INTEGER REFERENCES accounts(id) ON DELETE CASCADE,
+    sign VARCHAR(10),
+    set_category_id INTEGER REFERENCES categories(id) ON DELETE CASCADE,
+    set_name VARCHAR(255),
+    add_tags TEXT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_rules_user_id ON rules(user_id, priority);
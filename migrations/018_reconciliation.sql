@@ -0,0 +1,17 @@
+-- transaction status: uncleared, cleared (on a statement) or reconciled (matched by a reconciliation and locked)
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'uncleared';
+CREATE INDEX IF NOT EXISTS idx_transactions_account_status ON transactions(account_id, status);
+
+-- reconciliation (id, account_id, statement_date, statement_balance, transaction_count, user_id, created_at): an account matched against a statement
+CREATE TABLE IF NOT EXISTS reconciliations (
+    id SERIAL PRIMARY KEY,
+    account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    statement_date DATE NOT NULL,
+    statement_balance DECIMAL(12,2) NOT NULL,
+    transaction_count INTEGER NOT NULL DEFAULT 0,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_reconciliations_account_id ON reconciliations(account_id, statement_date);
+CREATE INDEX IF NOT EXISTS idx_reconciliations_user_id ON reconciliations(user_id);
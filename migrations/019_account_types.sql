@@ -0,0 +1,7 @@
+-- account types (checking, savings, cash, credit_card, loan, investment, asset) with their type-specific fields
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS type VARCHAR(20) NOT NULL DEFAULT 'checking';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS institution VARCHAR(255);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS credit_limit DECIMAL(12,2);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS statement_day INTEGER;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS interest_rate DECIMAL(7,4);
+
@@ -51,8 +51,11 @@ else
     echo -e "${YELLOW}⚠${NC} (Database might already exist)"
 fi
 echo -n "Running database migrations"
-PGPASSWORD=$DB_PASSWORD psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d $DB_NAME -f migrations/001_create_tables.sql > /dev/null 2>&1
-if [ $? -eq 0 ]; then
+MIGRATE_OK=0
+for f in migrations/*.sql; do
+    PGPASSWORD=$DB_PASSWORD psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d $DB_NAME -v ON_ERROR_STOP=1 -f $f > /dev/null 2>&1 || MIGRATE_OK=1
+done
+if [ $MIGRATE_OK -eq 0 ]; then
     echo -e "${GREEN}✓${NC}"
 else
     echo -e "${RED}✗${NC}"
@@ -70,7 +73,7 @@ else
 fi
 echo -e "${GREEN}Setup completed successfully!${NC}"
 echo -e "${BLUE}To start the server, run:${NC}"
-echo "go run cmd/api/main.go"
+echo "go run ./cmd/api"
 echo -e "${BLUE}Or use make:${NC}"
 echo "make run"
 echo -e "${BLUE}Then open your browser to:${NC}"
@@ -122,10 +122,29 @@ async function loadAccounts() {
         renderAccountsList(accounts);
         updateAccountDropdown();
         updateScheduledAccountDropdown();
+        updateBudgetAccountDropdown();
     } catch (error) {
         console.error('Error loading accounts:', error);
     }
 }
+const accountTypeLabels = {
+    checking: 'Checking',
+    savings: 'Savings',
+    cash: 'Cash',
+    credit_card: 'Credit Card',
+    loan: 'Loan',
+    investment: 'Investment',
+    asset: 'Asset'
+};
+const accountTypeIcons = {
+    checking: '🏦',
+    savings: '🐷',
+    cash: '💵',
+    credit_card: '💳',
+    loan: '📄',
+    investment: '📈',
+    asset: '🏠'
+};
 function renderAccountsList(accountsData) {
     const container = document.getElementById('accounts-list');
     if (!container) return;
@@ -136,12 +155,14 @@ function renderAccountsList(accountsData) {
     container.innerHTML = accountsData.map(account => `
         <div class="account-card">
             <div class="account-header">
-                <div class="account-icon">🏦</div>
+                <div class="account-icon">${accountTypeIcons[account.type] || '🏦'}</div>
                 <div>
                     <div class="account-name">${account.bank_name}</div>
+                    <div style="font-size: 12px; color: var(--text-secondary);">${accountTypeLabels[account.type] || 'Checking'}${account.institution ? ` · ${account.institution}` : ''}</div>
                 </div>
             </div>
             <div class="account-balance">Rp${account.amount.toFixed(2)}</div>
+            ${account.credit_limit != null ? `<div style="font-size: 12px; color: var(--text-secondary);">Available credit Rp${(account.credit_limit + account.amount).toFixed(2)} of Rp${account.credit_limit.toFixed(2)}</div>` : ''}
             <div style="margin-top:12px; display:flex; gap:8px;">
                 <button onclick="deleteAccount(${account.id})" class="btn-icon delete">
                     <svg class="w-4 h-4" fill="none" stroke="currentColor" viewBox="0 0 24 24">
@@ -216,8 +237,24 @@ function updateCategoryDropdown() {
 function updateBudgetCategoryDropdown() {
     const select = document.getElementById('budget-category');
     if (!select) return;
-    select.innerHTML = '<option value="">Select a category</option>' + 
-        categories.map(cat => `<option value="${cat.id}">${cat.name}</option>`).join('');
+    select.innerHTML = categories.map(cat => `<option value="${cat.id}">${cat.name}</option>`).join('');
+}
+function updateBudgetAccountDropdown() {
+    const select = document.getElementById('budget-account');
+    if (!select) return;
+    select.innerHTML = accounts.map(acc => `<option value="${acc.id}">${acc.bank_name}</option>`).join('');
+}
+function selectedIds(select) {
+    return Array.from(select.selectedOptions).map(o => parseInt(o.value));
+}
+function selectIds(select, ids) {
+    Array.from(select.options).forEach(o => { o.selected = (ids || []).includes(parseInt(o.value)); });
+}
+function budgetLabel(b) {
+    if (b.name) return b.name;
+    const names = (b.category_ids || []).map(id => categories.find(c => c.id === id)?.name || 'Unknown')
+        .concat((b.account_ids || []).map(id => accounts.find(a => a.id === id)?.bank_name || 'Unknown'));
+    return names.join(', ') || 'Unknown';
 }
 function updateScheduledCategoryDropdown() {
     const select = document.getElementById('scheduled-category');
@@ -261,11 +298,12 @@ async function deleteCategory(id) {
 }
 async function loadTransactions() {
     try {
-        const response = await fetch(`${API_BASE}/api/transactions`, {
+        const response = await fetch(`${API_BASE}/api/transactions?limit=200`, {
             headers: authHeaders()
         });
         if (!response.ok) throw new Error('Failed to fetch transactions');
-        transactions = await response.json() || [];
+        const page = await response.json();
+        transactions = page.transactions || [];
         renderTransactionsList();
         renderRecentTransactions();
     } catch (error) {
@@ -284,7 +322,7 @@ function renderRecentTransactions() {
         <tr>
             <td>${t.name}</td>
             <td>${t.category?.name || 'Uncategorized'}</td>
-            <td>${new Date(t.created_at).toLocaleDateString()}</td>
+            <td>${new Date(t.date).toLocaleDateString(undefined, { timeZone: 'UTC' })}</td>
             <td class="text-right ${t.amount >= 0 ? 'text-green-600' : 'text-red-600'}">
                 ${t.amount >= 0 ? '+' : ''}Rp${Math.abs(t.amount).toFixed(2)}
             </td>
@@ -302,7 +340,7 @@ function renderTransactionsList() {
         <tr>
             <td>${t.name}</td>
             <td>${t.category?.name || 'Uncategorized'}</td>
-            <td>${new Date(t.created_at).toLocaleDateString()}</td>
+            <td>${new Date(t.date).toLocaleDateString(undefined, { timeZone: 'UTC' })}</td>
             <td class="text-right ${t.amount >= 0 ? 'text-green-600' : 'text-red-600'}">
                 ${t.amount >= 0 ? '+' : ''}Rp${Math.abs(t.amount).toFixed(2)}
             </td>
@@ -327,6 +365,7 @@ function editTransaction(id) {
     document.getElementById('transaction-id').value = transaction.id;
     document.getElementById('transaction-name').value = transaction.name;
     document.getElementById('transaction-amount').value = transaction.amount;
+    document.getElementById('transaction-date').value = transaction.date.slice(0, 10);
     document.getElementById('transaction-category').value = transaction.category_id || '';
     document.getElementById('transaction-account').value = transaction.account_id || '';
     document.getElementById('transaction-modal-title').textContent = 'Edit Transaction';
@@ -366,13 +405,13 @@ function renderBudgetsList() {
         return;
     }
     tbody.innerHTML = budgets.map(b => {
-        const categoryName = categories.find(c => c.id === b.category_id)?.name || 'Unknown';
+        const categoryName = budgetLabel(b);
         const progressColor = b.percentage > 0 ? 'green' : 'red';
         const progressWidth = Math.min(100, Math.max(0, 100 - b.percentage));
         return `
         <tr>
             <td>${categoryName}</td>
-            <td>Rp${b.amount.toFixed(2)}</td>
+            <td>Rp${b.amount.toFixed(2)}${b.rolled_over ? ` <span style="font-size: 12px; color: var(--text-secondary);">(${b.rolled_over > 0 ? '+' : ''}${b.rolled_over.toFixed(2)} rolled over)</span>` : ''}</td>
             <td class="text-red-600">Rp${b.spent.toFixed(2)}</td>
             <td class="${b.remaining >= 0 ? 'text-green-600' : 'text-red-600'}">Rp${b.remaining.toFixed(2)}</td>
             <td>
@@ -403,9 +442,17 @@ function editBudget(id) {
     const budget = budgets.find(b => b.id === id);
     if (!budget) return;
     document.getElementById('budget-id').value = budget.id;
-    document.getElementById('budget-category').value = budget.category_id;
+    document.getElementById('budget-name').value = budget.name || '';
+    selectIds(document.getElementById('budget-category'), budget.category_ids);
+    selectIds(document.getElementById('budget-account'), budget.account_ids);
     document.getElementById('budget-amount').value = budget.amount;
     document.getElementById('budget-criteria').value = budget.criteria;
+    document.getElementById('budget-rollover').checked = budget.rollover;
+    document.getElementById('budget-week-start').value = budget.week_start || 'monday';
+    document.getElementById('budget-anchor-day').value = budget.anchor_day || '';
+    document.getElementById('budget-starts-on').value = budget.starts_on ? budget.starts_on.split('T')[0] : '';
+    document.getElementById('budget-ends-on').value = budget.ends_on ? budget.ends_on.split('T')[0] : '';
+    updateBudgetPeriodFields();
     document.getElementById('budget-modal-title').textContent = 'Edit Budget';
     document.getElementById('budget-modal').classList.add('show');
 }
@@ -452,7 +499,7 @@ function renderScheduledTransactionsList() {
             <td class="${st.amount >= 0 ? 'text-green-600' : 'text-red-600'}">
                 ${st.amount >= 0 ? '+' : ''}Rp${Math.abs(st.amount).toFixed(2)}
             </td>
-            <td style="text-transform: capitalize;">${st.repetition}</td>
+            <td style="text-transform: capitalize;">${st.repetition === 'custom' ? st.rrule : st.repetition}</td>
             <td>${nextDate}</td>
             <td>${categoryName}</td>
             <td>${accountName}</td>
@@ -524,6 +571,9 @@ async function loadDashboardStats() {
         document.getElementById('total-income').textContent = `Rp${stats.total_income.toFixed(2)}`;
         document.getElementById('total-expenses').textContent = `Rp${stats.total_expenses.toFixed(2)}`;
         document.getElementById('net-balance').textContent = `Rp${stats.balance.toFixed(2)}`;
+        document.getElementById('net-worth').textContent = `Rp${stats.net_worth.toFixed(2)}`;
+        document.getElementById('net-worth-meta').textContent =
+            `Assets Rp${stats.total_assets.toFixed(2)} · Liabilities Rp${stats.total_liabilities.toFixed(2)}`;
         document.getElementById('transaction-count').textContent = stats.transaction_count;
     } catch (error) {
         console.error('Error loading dashboard stats:', error);
@@ -533,6 +583,7 @@ function setupForms() {
     const transactionForm = document.getElementById('transaction-form');
     if (transactionForm) {
         transactionForm.addEventListener('submit', handleTransactionSubmit);
+        document.getElementById('transaction-name').addEventListener('change', suggestTransactionCategory);
     }
     const categoryForm = document.getElementById('category-form');
     if (categoryForm) {
@@ -551,6 +602,26 @@ function setupForms() {
         scheduledForm.addEventListener('submit', handleScheduledSubmit);
     }
 }
+async function suggestTransactionCategory() {
+    const select = document.getElementById('transaction-category');
+    const name = document.getElementById('transaction-name').value.trim();
+    if (!name || select.value) return;
+    const amount = document.getElementById('transaction-amount').value;
+    const params = new URLSearchParams({ name });
+    if (amount) params.set('amount', amount);
+    try {
+        const response = await fetch(`${API_BASE}/api/categories/suggest?${params}`, {
+            headers: authHeaders()
+        });
+        if (!response.ok) return;
+        const suggestion = await response.json();
+        if (suggestion.category_id && suggestion.confidence >= 0.5 && !select.value) {
+            select.value = suggestion.category_id;
+        }
+    } catch (error) {
+        console.error('Error suggesting category:', error);
+    }
+}
 async function handleTransactionSubmit(e) {
     e.preventDefault();
     const id = document.getElementById('transaction-id').value;
@@ -558,13 +629,15 @@ async function handleTransactionSubmit(e) {
     const amount = parseFloat(document.getElementById('transaction-amount').value);
     const categoryId = document.getElementById('transaction-category').value;
     const accountId = document.getElementById('transaction-account').value;
+    const date = document.getElementById('transaction-date').value;
     const data = {
         name,
         amount,
+        date: `${date}T00:00:00Z`,
         category_id: categoryId ? parseInt(categoryId) : null,
         account_id: accountId ? parseInt(accountId) : null
     };
-    if (amount < 0 && categoryId) {
+    if (amount < 0) {
         try {
             const budgetCheck = await fetch(`${API_BASE}/api/budgets/check`, {
                 method: 'POST',
@@ -573,7 +646,9 @@ async function handleTransactionSubmit(e) {
             });
             const budgetResult = await budgetCheck.json();
             if (budgetResult.exceeded) {
-                const confirmMsg = `WARNING: Budget Exceeded!\n\nBudget: Rp${budgetResult.budget.toFixed(2)}\nCurrent Spent: Rp${budgetResult.spent.toFixed(2)}\nNew Total: Rp${budgetResult.new_total.toFixed(2)}\n\nDo you want to continue?`;
+                const lines = budgetResult.budgets.filter(b => b.exceeded).map(b =>
+                    `${b.name}: Rp${b.new_total.toFixed(2)} of Rp${b.available.toFixed(2)} (spent Rp${b.spent.toFixed(2)})`);
+                const confirmMsg = `WARNING: Budget Exceeded!\n\n${lines.join('\n')}\n\nDo you want to continue?`;
                 if (!confirm(confirmMsg)) {
                     return;
                 }
@@ -618,15 +693,33 @@ async function handleCategorySubmit(e) {
         alert('Failed to save category');
     }
 }
+function updateAccountTypeFields() {
+    const type = document.getElementById('account-type').value;
+    document.getElementById('account-institution-group').classList.toggle('hidden', type === 'cash');
+    document.getElementById('account-credit-limit-group').classList.toggle('hidden', type !== 'credit_card');
+    document.getElementById('account-statement-day-group').classList.toggle('hidden', type !== 'credit_card' && type !== 'loan');
+    document.getElementById('account-interest-rate-group').classList.toggle('hidden', !['savings', 'credit_card', 'loan'].includes(type));
+    document.getElementById('account-amount-hint').classList.toggle('hidden', type !== 'credit_card' && type !== 'loan');
+}
 async function handleAccountSubmit(e) {
     e.preventDefault();
     const name = document.getElementById('account-name').value;
     const amount = parseFloat(document.getElementById('account-amount').value);
+    const type = document.getElementById('account-type').value;
+    const data = { bank_name: name, type, amount };
+    const institution = document.getElementById('account-institution').value.trim();
+    if (institution && type !== 'cash') data.institution = institution;
+    const creditLimit = document.getElementById('account-credit-limit').value;
+    if (creditLimit && type === 'credit_card') data.credit_limit = parseFloat(creditLimit);
+    const statementDay = document.getElementById('account-statement-day').value;
+    if (statementDay && (type === 'credit_card' || type === 'loan')) data.statement_day = parseInt(statementDay);
+    const interestRate = document.getElementById('account-interest-rate').value;
+    if (interestRate && ['savings', 'credit_card', 'loan'].includes(type)) data.interest_rate = parseFloat(interestRate);
     try {
         const response = await fetch(`${API_BASE}/api/accounts`, {
             method: 'POST',
             headers: authHeaders(),
-            body: JSON.stringify({ bank_name: name, amount })
+            body: JSON.stringify(data)
         });
         if (!response.ok) throw new Error('Failed to create account');
         closeAccountModal();
@@ -639,14 +732,32 @@ async function handleAccountSubmit(e) {
 async function handleBudgetSubmit(e) {
     e.preventDefault();
     const id = document.getElementById('budget-id').value;
-    const categoryId = parseInt(document.getElementById('budget-category').value);
+    const name = document.getElementById('budget-name').value.trim();
+    const categoryIds = selectedIds(document.getElementById('budget-category'));
+    const accountIds = selectedIds(document.getElementById('budget-account'));
     const amount = parseFloat(document.getElementById('budget-amount').value);
     const criteria = document.getElementById('budget-criteria').value;
+    const rollover = document.getElementById('budget-rollover').checked;
+    if (categoryIds.length === 0 && accountIds.length === 0) {
+        alert('Select at least one category or account');
+        return;
+    }
     const data = {
-        category_id: categoryId,
+        name,
+        category_ids: categoryIds,
+        account_ids: accountIds,
         amount,
-        criteria
+        criteria,
+        rollover
     };
+    if (criteria === 'weekly') {
+        data.week_start = document.getElementById('budget-week-start').value;
+    } else if (criteria === 'payday') {
+        data.anchor_day = parseInt(document.getElementById('budget-anchor-day').value);
+    } else if (criteria === 'custom') {
+        data.starts_on = new Date(document.getElementById('budget-starts-on').value).toISOString();
+        data.ends_on = new Date(document.getElementById('budget-ends-on').value).toISOString();
+    }
     try {
         const url = id ? `${API_BASE}/api/budgets/${id}` : `${API_BASE}/api/budgets`;
         const method = id ? 'PUT' : 'POST';
@@ -700,6 +811,7 @@ function openTransactionModal() {
     document.getElementById('transaction-id').value = '';
     document.getElementById('transaction-name').value = '';
     document.getElementById('transaction-amount').value = '';
+    document.getElementById('transaction-date').value = new Date().toLocaleDateString('en-CA');
     document.getElementById('transaction-category').value = '';
     document.getElementById('transaction-account').value = '';
     document.getElementById('transaction-modal-title').textContent = 'New Transaction';
@@ -720,6 +832,12 @@ function closeCategoryModal() {
 function openAccountModal() {
     document.getElementById('account-name').value = '';
     document.getElementById('account-amount').value = '0';
+    document.getElementById('account-type').value = 'checking';
+    document.getElementById('account-institution').value = '';
+    document.getElementById('account-credit-limit').value = '';
+    document.getElementById('account-statement-day').value = '';
+    document.getElementById('account-interest-rate').value = '';
+    updateAccountTypeFields();
     document.getElementById('account-modal').classList.add('show');
 }
 function closeAccountModal() {
@@ -727,12 +845,26 @@ function closeAccountModal() {
 }
 function openBudgetModal() {
     document.getElementById('budget-id').value = '';
-    document.getElementById('budget-category').value = '';
+    document.getElementById('budget-name').value = '';
+    selectIds(document.getElementById('budget-category'), []);
+    selectIds(document.getElementById('budget-account'), []);
     document.getElementById('budget-amount').value = '';
     document.getElementById('budget-criteria').value = 'monthly';
+    document.getElementById('budget-rollover').checked = false;
+    document.getElementById('budget-week-start').value = 'monday';
+    document.getElementById('budget-anchor-day').value = '';
+    document.getElementById('budget-starts-on').value = '';
+    document.getElementById('budget-ends-on').value = '';
+    updateBudgetPeriodFields();
     document.getElementById('budget-modal-title').textContent = 'New Budget';
     document.getElementById('budget-modal').classList.add('show');
 }
+function updateBudgetPeriodFields() {
+    const criteria = document.getElementById('budget-criteria').value;
+    document.getElementById('budget-week-start-group').classList.toggle('hidden', criteria !== 'weekly');
+    document.getElementById('budget-anchor-day-group').classList.toggle('hidden', criteria !== 'payday');
+    document.getElementById('budget-dates-group').classList.toggle('hidden', criteria !== 'custom');
+}
 function closeBudgetModal() {
     document.getElementById('budget-modal').classList.remove('show');
 }